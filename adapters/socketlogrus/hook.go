@@ -0,0 +1,79 @@
+// Package socketlogrus sends logrus logs to a socketlogger server
+package socketlogrus
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/Ryan-Johnson-1315/socketlogger"
+	"github.com/sirupsen/logrus"
+)
+
+// Hook is a logrus.Hook that sends every entry through a LoggerClient. Call SetReportCaller(true) on
+// the logger to send the caller. Entry data becomes structured fields
+type Hook struct {
+	client socketlogger.LoggerClient
+	levels []logrus.Level
+}
+
+// NewHook sends entries of every level to client. The logger keeps writing to its own output, set it to
+// io.Discard to only send to the server
+func NewHook(client socketlogger.LoggerClient) *Hook {
+	return &Hook{
+		client: client,
+		levels: logrus.AllLevels,
+	}
+}
+
+// SetLevel only sends entries at level or more severe
+func (h *Hook) SetLevel(level logrus.Level) {
+	h.levels = nil
+	for _, l := range logrus.AllLevels {
+		if l <= level {
+			h.levels = append(h.levels, l)
+		}
+	}
+}
+
+func (h *Hook) Levels() []logrus.Level {
+	return h.levels
+}
+
+func (h *Hook) Fire(entry *logrus.Entry) error {
+	msg := &socketlogger.LogMessage{
+		Message: entry.Message,
+	}
+	setLevel(msg, entry.Level)
+	if entry.Caller != nil {
+		msg.Caller = fmt.Sprintf("%s:%d", filepath.Base(entry.Caller.File), entry.Caller.Line)
+	}
+	if !entry.Time.IsZero() {
+		sent := entry.Time
+		msg.Time = &sent
+	}
+	if len(entry.Data) > 0 {
+		msg.Fields = make(socketlogger.Fields, len(entry.Data))
+		for key, value := range entry.Data {
+			if err, ok := value.(error); ok {
+				value = err.Error() // Most errors marshal to {}
+			}
+			msg.Fields[key] = value
+		}
+	}
+	h.client.Send(msg)
+	return nil
+}
+
+// Trace and debug are Dbg, info is Log, warn is Wrn and error and above are Err
+func setLevel(msg *socketlogger.LogMessage, level logrus.Level) {
+	switch {
+	case level >= logrus.DebugLevel:
+		msg.LogLevel = socketlogger.MessageLevelDbg
+	case level == logrus.InfoLevel:
+		msg.LogLevel = socketlogger.MessageLevelLog
+	case level == logrus.WarnLevel:
+		msg.LogLevel = socketlogger.MessageLevelWrn
+	default:
+		msg.LogLevel = socketlogger.MessageLevelErr
+	}
+}
@@ -0,0 +1,53 @@
+package socketlogrus
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/Ryan-Johnson-1315/socketlogger"
+	"github.com/sirupsen/logrus"
+)
+
+func TestHook(t *testing.T) {
+	dir := t.TempDir()
+	server := socketlogger.NewTcpLoggerServer()
+	server.SetLogFile(dir, "logrus.log")
+	if err := server.Bind(socketlogger.Connection{Addr: "127.0.0.1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	client := socketlogger.NewTcpLoggerClient()
+	client.Connect(socketlogger.Connection{}, server.Addr())
+	hook := NewHook(client)
+	hook.SetLevel(logrus.InfoLevel)
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	logger.SetLevel(logrus.DebugLevel)
+	logger.SetReportCaller(true)
+	logger.AddHook(hook)
+	_, _, line, _ := runtime.Caller(0)
+	logger.Debug("filtered out")
+	logger.WithField("camera", 2).Warn("frame dropped")
+	logger.WithError(errors.New("connection reset")).Error("upload failed")
+	client.Disconnect()
+	server.Shutdown()
+
+	dat, _ := os.ReadFile(filepath.Join(dir, "logrus.log"))
+	for _, expected := range []string{
+		fmt.Sprintf("\033[33m | hook_test.go:%d -- frame dropped camera=2", line+2),
+		fmt.Sprintf("\033[31m | hook_test.go:%d -- upload failed error=\"connection reset\"", line+3),
+	} {
+		if !strings.Contains(string(dat), expected) {
+			t.Errorf("%q missing from log file:\n%s", expected, dat)
+		}
+	}
+	if strings.Contains(string(dat), "filtered out") {
+		t.Errorf("Debug entries are below the hook level:\n%s", dat)
+	}
+}
@@ -0,0 +1,107 @@
+// Package socketzap sends zap logs to a socketlogger server
+package socketzap
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/Ryan-Johnson-1315/socketlogger"
+	"go.uber.org/zap/zapcore"
+)
+
+// Core is a zapcore.Core that sends every entry through a LoggerClient. Build the logger with
+// zap.AddCaller() to send the caller. Fields become structured fields, namespaces are joined by dots
+type Core struct {
+	zapcore.LevelEnabler
+	client socketlogger.LoggerClient
+	fields socketlogger.Fields // From With
+}
+
+// NewCore sends entries enabled by enabler, e.g. zapcore.InfoLevel, to client. Combine it with other
+// cores using zapcore.NewTee to keep the console output
+func NewCore(client socketlogger.LoggerClient, enabler zapcore.LevelEnabler) *Core {
+	return &Core{
+		LevelEnabler: enabler,
+		client:       client,
+	}
+}
+
+func (c *Core) With(fields []zapcore.Field) zapcore.Core {
+	child := *c
+	child.fields = c.encode(fields)
+	return &child
+}
+
+func (c *Core) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(entry.Level) {
+		return checked.AddCore(entry, c)
+	}
+	return checked
+}
+
+func (c *Core) Write(entry zapcore.Entry, fields []zapcore.Field) error {
+	msg := &socketlogger.LogMessage{
+		Message: entry.Message,
+		Fields:  c.encode(fields),
+	}
+	setLevel(msg, entry.Level)
+	if entry.Caller.Defined {
+		msg.Caller = fmt.Sprintf("%s:%d", filepath.Base(entry.Caller.File), entry.Caller.Line)
+	}
+	if !entry.Time.IsZero() {
+		msg.Time = &entry.Time
+	}
+	if entry.Stack != "" {
+		msg.Message += "\n" + entry.Stack
+	}
+	c.client.Send(msg)
+	return nil
+}
+
+// Sync does nothing, messages are queued by the client. Disconnect the client to flush it
+func (c *Core) Sync() error {
+	return nil
+}
+
+// Adds fields to a copy of the fields from With
+func (c *Core) encode(fields []zapcore.Field) socketlogger.Fields {
+	if len(c.fields) == 0 && len(fields) == 0 {
+		return nil
+	}
+	encoder := zapcore.NewMapObjectEncoder()
+	for _, field := range fields {
+		field.AddTo(encoder)
+	}
+
+	encoded := make(socketlogger.Fields, len(c.fields)+len(encoder.Fields))
+	for key, value := range c.fields {
+		encoded[key] = value
+	}
+	flatten(encoded, "", encoder.Fields)
+	return encoded
+}
+
+// Namespaces are nested maps in the encoder
+func flatten(fields socketlogger.Fields, prefix string, values map[string]interface{}) {
+	for key, value := range values {
+		if nested, ok := value.(map[string]interface{}); ok {
+			flatten(fields, prefix+key+".", nested)
+		} else {
+			fields[prefix+key] = value
+		}
+	}
+}
+
+// Debug is Dbg, info is Log, warn is Wrn and error and above are Err
+func setLevel(msg *socketlogger.LogMessage, lvl zapcore.Level) {
+	switch {
+	case lvl < zapcore.InfoLevel:
+		msg.LogLevel = socketlogger.MessageLevelDbg
+	case lvl < zapcore.WarnLevel:
+		msg.LogLevel = socketlogger.MessageLevelLog
+	case lvl < zapcore.ErrorLevel:
+		msg.LogLevel = socketlogger.MessageLevelWrn
+	default:
+		msg.LogLevel = socketlogger.MessageLevelErr
+	}
+}
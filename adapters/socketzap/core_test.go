@@ -0,0 +1,49 @@
+package socketzap
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/Ryan-Johnson-1315/socketlogger"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestCore(t *testing.T) {
+	dir := t.TempDir()
+	server := socketlogger.NewTcpLoggerServer()
+	server.SetLogFile(dir, "zap.log")
+	if err := server.Bind(socketlogger.Connection{Addr: "127.0.0.1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	client := socketlogger.NewTcpLoggerClient()
+	client.Connect(socketlogger.Connection{}, server.Addr())
+	logger := zap.New(NewCore(client, zapcore.InfoLevel), zap.AddCaller())
+	_, _, line, _ := runtime.Caller(0)
+	logger.Debug("filtered out")
+	logger.With(zap.String("service", "video")).Warn("slow request", zap.Namespace("req"), zap.Int("ms", 812))
+	logger.Error("upload failed", zap.Error(errors.New("connection reset")))
+	zap.New(NewCore(client.Named("camera"), zapcore.InfoLevel), zap.AddCaller()).Info("from a child")
+	client.Disconnect()
+	server.Shutdown()
+
+	dat, _ := os.ReadFile(filepath.Join(dir, "zap.log"))
+	for _, expected := range []string{
+		fmt.Sprintf("\033[33m | core_test.go:%d -- slow request req.ms=812 service=video", line+2),
+		fmt.Sprintf("\033[31m | core_test.go:%d -- upload failed error=\"connection reset\"", line+3),
+		fmt.Sprintf(" | core_test.go:%d -- from a child logger=camera", line+4),
+	} {
+		if !strings.Contains(string(dat), expected) {
+			t.Errorf("%q missing from log file:\n%s", expected, dat)
+		}
+	}
+	if strings.Contains(string(dat), "filtered out") {
+		t.Errorf("Debug entries are below the core level:\n%s", dat)
+	}
+}
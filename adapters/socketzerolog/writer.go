@@ -0,0 +1,107 @@
+// Package socketzerolog sends zerolog logs to a socketlogger server
+package socketzerolog
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Ryan-Johnson-1315/socketlogger"
+	"github.com/rs/zerolog"
+)
+
+// Writer is a zerolog.LevelWriter that sends every event through a LoggerClient. Add .Caller() to the
+// logger context to send the caller. The other fields of the event become structured fields
+type Writer struct {
+	client socketlogger.LoggerClient
+}
+
+func NewWriter(client socketlogger.LoggerClient) *Writer {
+	return &Writer{
+		client: client,
+	}
+}
+
+// Write takes the level from the event, used when the writer is wrapped in something that hides WriteLevel
+func (w *Writer) Write(p []byte) (int, error) {
+	return w.write(zerolog.NoLevel, p)
+}
+
+func (w *Writer) WriteLevel(level zerolog.Level, p []byte) (int, error) {
+	return w.write(level, p)
+}
+
+func (w *Writer) write(level zerolog.Level, p []byte) (int, error) {
+	var event map[string]interface{}
+	decoder := json.NewDecoder(strings.NewReader(string(p)))
+	decoder.UseNumber() // Keeps large integers intact
+	if err := decoder.Decode(&event); err != nil {
+		return 0, err
+	}
+
+	msg := &socketlogger.LogMessage{}
+	if text, ok := event[zerolog.MessageFieldName].(string); ok {
+		msg.Message = text
+	}
+	if name, ok := event[zerolog.LevelFieldName].(string); ok && level == zerolog.NoLevel {
+		level, _ = zerolog.ParseLevel(name)
+	}
+	setLevel(msg, level)
+	if caller, ok := event[zerolog.CallerFieldName].(string); ok {
+		msg.Caller = filepath.Base(caller)
+	}
+	if sent, ok := eventTime(event[zerolog.TimestampFieldName]); ok {
+		msg.Time = &sent
+	}
+
+	for _, key := range []string{zerolog.MessageFieldName, zerolog.LevelFieldName, zerolog.CallerFieldName, zerolog.TimestampFieldName} {
+		delete(event, key)
+	}
+	if len(event) > 0 {
+		msg.Fields = socketlogger.Fields(event)
+	}
+	w.client.Send(msg)
+	return len(p), nil
+}
+
+// Parses the timestamp in whichever zerolog.TimeFieldFormat it was written in
+func eventTime(value interface{}) (time.Time, bool) {
+	switch v := value.(type) {
+	case string:
+		sent, err := time.Parse(zerolog.TimeFieldFormat, v)
+		if err != nil {
+			sent, err = time.Parse(time.RFC3339Nano, v)
+		}
+		return sent, err == nil
+	case json.Number:
+		n, err := v.Int64()
+		if err != nil {
+			return time.Time{}, false
+		}
+		switch zerolog.TimeFieldFormat {
+		case zerolog.TimeFormatUnixMs:
+			return time.UnixMilli(n), true
+		case zerolog.TimeFormatUnixMicro:
+			return time.UnixMicro(n), true
+		case zerolog.TimeFormatUnixNano:
+			return time.Unix(0, n), true
+		}
+		return time.Unix(n, 0), true
+	}
+	return time.Time{}, false
+}
+
+// Trace and debug are Dbg, info and no level are Log, warn is Wrn and error and above are Err
+func setLevel(msg *socketlogger.LogMessage, level zerolog.Level) {
+	switch level {
+	case zerolog.TraceLevel, zerolog.DebugLevel:
+		msg.LogLevel = socketlogger.MessageLevelDbg
+	case zerolog.InfoLevel, zerolog.NoLevel:
+		msg.LogLevel = socketlogger.MessageLevelLog
+	case zerolog.WarnLevel:
+		msg.LogLevel = socketlogger.MessageLevelWrn
+	default:
+		msg.LogLevel = socketlogger.MessageLevelErr
+	}
+}
@@ -0,0 +1,47 @@
+package socketzerolog
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/Ryan-Johnson-1315/socketlogger"
+	"github.com/rs/zerolog"
+)
+
+func TestWriter(t *testing.T) {
+	dir := t.TempDir()
+	server := socketlogger.NewTcpLoggerServer()
+	server.SetLogFile(dir, "zerolog.log")
+	if err := server.Bind(socketlogger.Connection{Addr: "127.0.0.1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	client := socketlogger.NewTcpLoggerClient()
+	client.Connect(socketlogger.Connection{}, server.Addr())
+	writer := NewWriter(client)
+	logger := zerolog.New(writer).With().Timestamp().Caller().Logger()
+	_, _, line, _ := runtime.Caller(0)
+	logger.Warn().Int("camera", 2).Msg("frame dropped")
+	logger.Debug().Str("path", "/var/log").Msg("checking")
+	writer.Write([]byte(`{"level":"error","message":"written without a level"}`))
+	client.Disconnect()
+	server.Shutdown()
+
+	dat, _ := os.ReadFile(filepath.Join(dir, "zerolog.log"))
+	for _, expected := range []string{
+		fmt.Sprintf("\033[33m | writer_test.go:%d -- frame dropped camera=2", line+1),
+		fmt.Sprintf("\033[36m | writer_test.go:%d -- checking path=/var/log", line+2),
+		"\033[31m |  -- written without a level",
+	} {
+		if !strings.Contains(string(dat), expected) {
+			t.Errorf("%q missing from log file:\n%s", expected, dat)
+		}
+	}
+	if _, err := writer.Write([]byte("not json")); err == nil {
+		t.Error("Events that aren't JSON should be rejected")
+	}
+}
@@ -1,21 +1,32 @@
 package socketlogger
 
 import (
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
-	"log"
 	"net"
+	"strconv"
+	"sync"
 	"time"
 )
 
 type Client interface {
 	Connect(client, server Connection) error
 	Disconnect()
+	SetTLSConfig(config *tls.Config) error
+	SetFraming(framing Framing) error
+	SetReconnect(initial, max time.Duration, bufferSize int) error
+	SetSpoolFile(path string) error
+	SetReliable(reliable bool) error
+	SetStatusCallback(callback func(status ConnectionStatus, err error))
+	Status() ConnectionStatus
+	Send(msg SocketMessage)
 
 	start()
 	buildSocket(local, remote Connection) (string, net.Conn, error)
 	writeOverSocket(chan SocketMessage)
 	setMsgChannel(chan SocketMessage)
+	encode(msg SocketMessage) []byte
 	init(i interface{})
 }
 
@@ -25,20 +36,37 @@ type client struct {
 	remoteAddr   net.Addr
 	this         interface{}
 	disconnected chan bool
+	statusLock   sync.Mutex
+	status       ConnectionStatus
+	onStatus     func(ConnectionStatus, error)
 }
 
 func (c *client) Connect(client, server Connection) error {
-	var err error = nil
 	inst, ok := c.this.(Client)
 	if !ok {
-		err = fmt.Errorf(`type is not interface type "Server". Type %t`, c.this)
-	} else {
-		c.connectionProtocol, c.sock, err = inst.buildSocket(client, server)
+		return fmt.Errorf(`type is not interface type "Server". Type %t`, c.this)
+	}
+	var err error
+	c.connectionProtocol, c.sock, err = inst.buildSocket(client, server)
+	c.disconnected = make(chan bool)
+	if err != nil {
+		c.setStatus(StatusDisconnected, err)
+		inst.setMsgChannel(c.msgsToSend)
+		go c.discard(c.msgsToSend)
+		return err
+	}
+	if c.sock != nil {
 		c.msgsToSend <- newLogMessage(MessageLevelSuccess, "Built %s at %s", c.connectionProtocol, c.sock.LocalAddr())
-		c.disconnected = make(chan bool)
 	}
 	c.start()
-	return err
+	return nil
+}
+
+// Drops everything sent after Connect failed, so sending and Disconnect don't block
+func (c *client) discard(msgsToSend chan SocketMessage) {
+	for range msgsToSend {
+	}
+	c.disconnected <- true
 }
 
 func (c *client) Disconnect() {
@@ -46,6 +74,40 @@ func (c *client) Disconnect() {
 	<-c.disconnected
 }
 
+// Send queues a message that was built somewhere else, e.g. received by a server, keeping its caller.
+// A copy is sent, so msg can be reused
+func (c *client) Send(msg SocketMessage) {
+	c.msgsToSend <- copyMessage(msg)
+}
+
+// Status returns the current state of the connection to the server
+func (c *client) Status() ConnectionStatus {
+	c.statusLock.Lock()
+	defer c.statusLock.Unlock()
+	return c.status
+}
+
+// SetStatusCallback registers a function that is called every time the connection state changes.
+// err holds the reason when the connection is lost, or what was left unsent once it is disconnected.
+// The callback must not block
+func (c *client) SetStatusCallback(callback func(status ConnectionStatus, err error)) {
+	c.statusLock.Lock()
+	defer c.statusLock.Unlock()
+	c.onStatus = callback
+}
+
+func (c *client) setStatus(status ConnectionStatus, err error) {
+	c.statusLock.Lock()
+	changed := c.status != status
+	c.status = status
+	callback := c.onStatus
+	c.statusLock.Unlock()
+
+	if changed && callback != nil {
+		callback(status, err)
+	}
+}
+
 func (c *client) start() {
 	c.this.(Client).setMsgChannel(c.msgsToSend)
 	go c.this.(Client).writeOverSocket(c.msgsToSend)
@@ -62,6 +124,28 @@ func (c *client) init(i interface{}) {
 
 type udpClient struct {
 	client
+	session  string
+	seq      uint64
+	reliable bool
+}
+
+func (u *udpClient) SetTLSConfig(config *tls.Config) error {
+	return fmt.Errorf("TLS is not supported by the UDP client")
+}
+
+func (u *udpClient) SetReconnect(initial, max time.Duration, bufferSize int) error {
+	return fmt.Errorf("reconnecting is not supported by the UDP client")
+}
+
+// SetReliable makes the client keep every message until the server acknowledges it, retransmitting
+// anything that is lost. The server must be in reliable mode too. Must be called before Connect
+func (u *udpClient) SetReliable(reliable bool) error {
+	u.reliable = reliable
+	return nil
+}
+
+func (u *udpClient) SetSpoolFile(path string) error {
+	return fmt.Errorf("spooling is not supported by the UDP client")
 }
 
 func (u *udpClient) buildSocket(local Connection, remote Connection) (string, net.Conn, error) {
@@ -74,59 +158,485 @@ func (u *udpClient) buildSocket(local Connection, remote Connection) (string, ne
 		IP:   net.ParseIP(remote.Addr),
 		Port: remote.Port,
 	}
+	if err == nil {
+		u.session = newSessionID()
+		u.setStatus(StatusConnected, nil)
+	}
 	return "UDP Client", sock, err
 }
 
 func (u *udpClient) writeOverSocket(msgsToSend chan SocketMessage) {
-	sock, goodSock := u.sock.(*net.UDPConn)
-	addr, goodAddr := u.remoteAddr.(*net.UDPAddr)
-
-	if !goodSock || !goodAddr {
-		panic(fmt.Errorf("udp client socket/server addr is not *net.UDPConn/*net.UDPAddr. Type: %T/%T", sock, addr))
+	if _, ok := u.sock.(net.PacketConn); !ok {
+		panic(fmt.Errorf("udp client socket is not a net.PacketConn. Type: %T", u.sock))
 	} else {
-		for msg := range msgsToSend {
-			bytes, _ := json.Marshal(msg)
-			sock.WriteToUDP(bytes, addr)
+		var err error // What was left unacknowledged, given to the status callback
+		if u.reliable {
+			err = u.writeReliably(msgsToSend)
+		} else {
+			for msg := range msgsToSend {
+				u.number(msg)
+				u.send(encodeFrame(u.this.(Client).encode(msg), u.framing))
+			}
 		}
-		sock.Close()
+		u.sock.Close()
+		u.setStatus(StatusDisconnected, err)
 		u.disconnected <- true // Notify that we have finished writing
 	}
 }
 
+// Connected sockets (unixgram without a local path) have no remote address to write to
+func (u *udpClient) send(frame []byte) {
+	datagrams := [][]byte{frame}
+	if u.framing == framingGelfChunked {
+		if datagrams = gelfChunks(frame); datagrams == nil {
+			// Tell the server what was lost instead
+			datagrams = gelfChunks(u.this.(Client).encode(newLogMessage(MessageLevelErr, "Dropped a GELF message, %d bytes is too large to send", len(frame))))
+		}
+	}
+	for _, datagram := range datagrams {
+		if u.remoteAddr == nil {
+			u.sock.Write(datagram)
+		} else {
+			u.sock.(net.PacketConn).WriteTo(datagram, u.remoteAddr)
+		}
+	}
+}
+
+// Stamps the session and next sequence number on msg, returns 0 if msg can't carry them
+func (u *udpClient) number(msg SocketMessage) uint64 {
+	e, ok := msg.(enveloped)
+	if !ok {
+		return 0
+	}
+	u.seq++
+	e.env().Session = u.session
+	e.env().Seq = u.seq
+	return u.seq
+}
+
+// Keeps every datagram until the server acknowledges it, retransmitting with backoff. Stops taking new
+// messages while ackWindow are outstanding. On Disconnect waits up to ackLinger for the last acks. A
+// server that sends no ack at all within ackLinger is not in reliable mode, so the client stops waiting
+// for acks and sends like an unreliable client, warning the server about it. Returns an error if
+// messages were never acknowledged
+func (u *udpClient) writeReliably(msgsToSend chan SocketMessage) error {
+	acks := make(chan ackMessage, 100)
+	done := make(chan bool)
+	defer close(done)
+	go u.readAcks(u.sock.(net.PacketConn), acks, done)
+
+	ticker := time.NewTicker(ackTimeout / 2)
+	defer ticker.Stop()
+	var linger, silent <-chan time.Time
+	heard := false // The server has acknowledged something
+	unacked := make(map[uint64]*unackedDatagram)
+
+	msgs := msgsToSend
+	for msgs != nil || len(unacked) > 0 {
+		incoming := msgs
+		if len(unacked) >= ackWindow {
+			incoming = nil // Wait for the server to catch up
+		}
+
+		select {
+		case msg, ok := <-incoming:
+			if !ok {
+				msgs = nil
+				linger = time.After(ackLinger)
+				continue
+			}
+			seq := u.number(msg)
+			frame := encodeFrame(u.this.(Client).encode(msg), u.framing)
+			u.send(frame)
+			if seq != 0 {
+				unacked[seq] = &unackedDatagram{
+					msg:     msg,
+					frame:   frame,
+					backoff: ackTimeout,
+					due:     time.Now().Add(ackTimeout),
+				}
+				if !heard && silent == nil {
+					silent = time.After(ackLinger)
+				}
+			}
+		case ack := <-acks:
+			if ack.Session == u.session {
+				heard, silent = true, nil
+				for seq := range unacked {
+					if ack.acks(seq) {
+						delete(unacked, seq)
+					}
+				}
+			}
+		case now := <-ticker.C:
+			for _, datagram := range unacked {
+				if now.After(datagram.due) {
+					if e, ok := datagram.msg.(enveloped); ok && !e.env().Buffered {
+						e.env().Buffered = true // May arrive long after it was sent first
+						datagram.frame = encodeFrame(u.this.(Client).encode(datagram.msg), u.framing)
+					}
+					u.send(datagram.frame)
+					if datagram.backoff < maxAckBackoff {
+						datagram.backoff *= 2
+					}
+					datagram.due = now.Add(minDuration(datagram.backoff, maxAckBackoff))
+				}
+			}
+		case <-linger:
+			return fmt.Errorf("%s disconnected with %d messages never acknowledged by %s", u.connectionProtocol, len(unacked), u.remoteAddr)
+		case <-silent:
+			warning := newLogMessage(MessageLevelWrn, "%s never got an ack from %s, which is not in reliable mode. Sending without acks", u.connectionProtocol, u.remoteAddr)
+			u.number(warning)
+			u.send(encodeFrame(u.this.(Client).encode(warning), u.framing))
+			for msg := range msgsToSend {
+				u.number(msg)
+				u.send(encodeFrame(u.this.(Client).encode(msg), u.framing))
+			}
+			return nil
+		}
+	}
+	return nil
+}
+
+func (u *udpClient) readAcks(sock net.PacketConn, acks chan ackMessage, done chan bool) {
+	buf := make([]byte, maxDatagramSize)
+	for {
+		n, _, err := sock.ReadFrom(buf)
+		if err != nil {
+			return // Socket closed by writeOverSocket
+		}
+
+		var ack ackMessage
+		if json.Unmarshal(buf[:n], &ack) != nil || ack.Session == "" {
+			continue
+		}
+		select {
+		case acks <- ack:
+		case <-done:
+			return
+		}
+	}
+}
+
+// A sent datagram waiting for its ack
+type unackedDatagram struct {
+	msg     SocketMessage
+	frame   []byte
+	backoff time.Duration // Doubles on every retransmit
+	due     time.Time     // When to retransmit
+}
+
 type tcpClient struct {
 	client
-	connected bool
+	network    string // tcp, or unix for unixClient
+	address    string // host:port or socket path of the server, kept for reconnecting
+	serverName string // Name used to verify the server certificate
+	backoffMin time.Duration
+	backoffMax time.Duration
+	pending    *backlog
+	spool      *spool // nil unless SetSpoolFile has been called
+}
+
+// Result of a background dial
+type dialResult struct {
+	conn net.Conn
+	err  error
+}
+
+// SetReconnect sets the backoff between reconnect attempts, doubling from initial up to max,
+// and how many messages are held while disconnected. Must be called before Connect
+func (t *tcpClient) SetReconnect(initial, max time.Duration, bufferSize int) error {
+	if initial <= 0 || max < initial || bufferSize <= 0 {
+		return fmt.Errorf("invalid reconnect settings: initial %v, max %v, buffer size %d", initial, max, bufferSize)
+	}
+	t.backoffMin = initial
+	t.backoffMax = max
+	t.pending = newBacklog(bufferSize)
+	return nil
+}
+
+// SetSpoolFile makes the client write messages to path instead of memory while the server is unreachable.
+// The file is drained on reconnect, and on the next Connect if the process exits first. Must be called before Connect
+func (t *tcpClient) SetSpoolFile(path string) error {
+	spool, err := openSpool(path)
+	if err == nil {
+		t.spool = spool
+	}
+	return err
+}
+
+func (t *tcpClient) SetReliable(reliable bool) error {
+	return fmt.Errorf("reliable mode is only needed by UDP clients, TCP is already reliable")
 }
 
 func (t *tcpClient) buildSocket(local Connection, remote Connection) (string, net.Conn, error) {
-	tcpAddr, err := net.ResolveTCPAddr(tcpProtocol, fmt.Sprintf("%s:%d", remote.Addr, remote.Port))
-	if err != nil {
-		log.Fatal(err)
+	t.network = tcpProtocol
+	t.address = net.JoinHostPort(remote.Addr, strconv.Itoa(remote.Port))
+	t.serverName = remote.Addr
+
+	protocol := "TCP Client"
+	if t.tlsConfig != nil {
+		protocol = "TLS Client"
+	}
+	return t.connect(protocol)
+}
+
+// Makes the first attempt at connecting, writeOverSocket keeps trying in the background if it fails
+func (t *tcpClient) connect(protocol string) (string, net.Conn, error) {
+	if t.backoffMin == 0 {
+		t.SetReconnect(reconnectMin, reconnectMax, backlogSize)
 	}
 
-	sock, err := net.DialTCP(tcpProtocol, nil, tcpAddr)
+	sock, err := t.dial()
 	if err != nil {
-		log.Fatal(err)
-	} else {
-		time.Sleep(50 * time.Millisecond)
+		t.setStatus(StatusConnecting, err)
+		return protocol, nil, nil
 	}
+	time.Sleep(50 * time.Millisecond)
 
-	t.connected = (err == nil)
-	return "TCP Client", sock, err
+	t.setStatus(StatusConnected, nil)
+	return protocol, sock, nil
+}
+
+func (t *tcpClient) dial() (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: dialTimeout}
+	if t.tlsConfig == nil {
+		return dialer.Dial(t.network, t.address)
+	}
+
+	config := t.tlsConfig
+	if config.ServerName == "" && !config.InsecureSkipVerify {
+		config = config.Clone()
+		config.ServerName = t.serverName
+	}
+	return tls.DialWithDialer(dialer, t.network, t.address, config)
 }
 
 func (t *tcpClient) writeOverSocket(msgsToSend chan SocketMessage) {
-	if t.connected {
-		sock, ok := t.sock.(*net.TCPConn)
-		if !ok {
-			log.Fatal(fmt.Errorf("socket is not *net.TCPConn type. Type: %T", t.sock))
-		} else {
-			for msg := range msgsToSend {
-				bytes, _ := json.Marshal(msg)
-				sock.Write(bytes)
+	var retry <-chan time.Time
+	dialed := make(chan dialResult)
+	lost := make(chan net.Conn)
+	done := make(chan bool)
+	delay := t.backoffMin
+	everConnected := t.sock != nil
+
+	conn := t.sock
+	disconnect := func(err error) {
+		conn.Close()
+		conn = nil
+		t.spill()
+		t.setStatus(StatusConnecting, err)
+		retry = time.After(delay)
+		delay = minDuration(delay*2, t.backoffMax)
+	}
+
+	if conn != nil {
+		go t.watch(conn, lost, done)
+		if err := t.flush(conn); err != nil { // Anything spooled by a previous run
+			disconnect(err)
+		}
+	} else {
+		retry = time.After(0)
+	}
+
+	for {
+		select {
+		case msg, ok := <-msgsToSend:
+			if !ok {
+				if conn != nil {
+					t.flush(conn)
+					conn.Close()
+				}
+				t.spill()
+				var unsent error // Given to the status callback
+				if t.pending.len() > 0 {
+					unsent = fmt.Errorf("%s disconnected with %d unsent messages to %s", t.connectionProtocol, t.pending.len(), t.address)
+				}
+				if t.spool != nil {
+					if !t.spool.empty() {
+						unsent = fmt.Errorf("%s left unsent messages in %s", t.connectionProtocol, t.spool.path)
+					}
+					t.spool.close()
+				}
+				close(done)
+				t.setStatus(StatusDisconnected, unsent)
+				t.disconnected <- true // Notify that we have finished sending over socket
+				return
+			}
+
+			if e, ok := msg.(enveloped); ok && (conn == nil || t.pending.len() > 0 || (t.spool != nil && !t.spool.empty())) {
+				e.env().Buffered = true // Waits for the server to come back
+			}
+			bytes := t.this.(Client).encode(msg)
+			// Once anything is spooled everything after it is too, so the order is kept
+			if t.spool == nil || (conn != nil && t.spool.empty()) || t.spool.append(bytes) != nil {
+				t.pending.push(bytes)
+			}
+			if conn != nil {
+				if err := t.flush(conn); err != nil {
+					disconnect(err)
+				}
+			}
+		case <-retry:
+			retry = nil
+			go func() {
+				c, err := t.dial()
+				select {
+				case dialed <- dialResult{c, err}:
+				case <-done:
+					if c != nil {
+						c.Close()
+					}
+				}
+			}()
+		case result := <-dialed:
+			if result.err != nil {
+				t.setStatus(StatusConnecting, result.err)
+				retry = time.After(delay)
+				delay = minDuration(delay*2, t.backoffMax)
+				continue
+			}
+
+			conn = result.conn
+			delay = t.backoffMin
+			if dropped := t.pending.resetDropped(); dropped > 0 {
+				t.pending.pushFront(t.this.(Client).encode(newLogMessage(MessageLevelWrn, "%s dropped %d messages while disconnected", t.connectionProtocol, dropped)))
+			}
+			if everConnected {
+				t.pending.pushFront(t.this.(Client).encode(newLogMessage(MessageLevelSuccess, "%s reconnected at %s", t.connectionProtocol, conn.LocalAddr())))
+			} else {
+				t.pending.pushFront(t.this.(Client).encode(newLogMessage(MessageLevelSuccess, "Built %s at %s", t.connectionProtocol, conn.LocalAddr())))
+				everConnected = true
+			}
+			t.setStatus(StatusConnected, nil)
+			go t.watch(conn, lost, done)
+			if err := t.flush(conn); err != nil {
+				disconnect(err)
+			}
+		case c := <-lost:
+			if c == conn {
+				disconnect(fmt.Errorf("connection to %s lost", t.address))
+			}
+		}
+	}
+}
+
+// Writes everything waiting in the backlog then the spool, oldest first. Messages are only removed once written
+func (t *tcpClient) flush(conn net.Conn) error {
+	send := func(msg []byte) error {
+		conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+		_, err := conn.Write(encodeFrame(msg, t.framing))
+		return err
+	}
+
+	for t.pending.len() > 0 {
+		if err := send(t.pending.peek()); err != nil {
+			return err
+		}
+		t.pending.pop()
+	}
+	if t.spool != nil {
+		return t.spool.drain(send)
+	}
+	return nil
+}
+
+// Moves the in memory backlog to the spool, if there is one
+func (t *tcpClient) spill() {
+	for t.spool != nil && t.pending.len() > 0 {
+		if err := t.spool.append(t.pending.peek()); err != nil {
+			return
+		}
+		t.pending.pop()
+	}
+}
+
+// The server never writes to the client, so the only thing a read can return is the connection closing
+func (t *tcpClient) watch(conn net.Conn, lost chan net.Conn, done chan bool) {
+	buf := make([]byte, 1)
+	for {
+		if _, err := conn.Read(buf); err != nil {
+			select {
+			case lost <- conn:
+			case <-done:
 			}
-			sock.Close()
-			t.disconnected <- true // Notify that we have finished sending over socket
+			return
 		}
 	}
 }
+
+// Bounded FIFO of marshalled messages waiting to be written, the oldest are dropped when full
+type backlog struct {
+	msgs    [][]byte
+	size    int
+	dropped int
+}
+
+func newBacklog(size int) *backlog {
+	return &backlog{size: size}
+}
+
+func (b *backlog) push(msg []byte) {
+	if len(b.msgs) >= b.size {
+		b.pop()
+		b.dropped++
+	}
+	b.msgs = append(b.msgs, msg)
+}
+
+func (b *backlog) pushFront(msg []byte) {
+	b.msgs = append([][]byte{msg}, b.msgs...)
+}
+
+func (b *backlog) peek() []byte {
+	return b.msgs[0]
+}
+
+func (b *backlog) pop() {
+	b.msgs[0] = nil
+	b.msgs = b.msgs[1:]
+}
+
+func (b *backlog) len() int {
+	return len(b.msgs)
+}
+
+func (b *backlog) resetDropped() int {
+	dropped := b.dropped
+	b.dropped = 0
+	return dropped
+}
+
+// Messages are sent as JSON unless the client overrides this, e.g. syslog. Values JSON can't hold are
+// sent as text instead of dropping the message
+func (c *client) encode(msg SocketMessage) []byte {
+	bytes, err := json.Marshal(msg)
+	if err == nil {
+		return bytes
+	}
+	switch inst := msg.(type) {
+	case *LogMessage:
+		safe := *inst
+		safe.Fields = make(Fields, len(inst.Fields))
+		for key, value := range inst.Fields {
+			safe.Fields[key] = jsonValue(value)
+		}
+		msg = &safe
+	case *CsvMessage:
+		safe := *inst
+		safe.Row = make([]interface{}, len(inst.Row))
+		for i, value := range inst.Row {
+			safe.Row[i] = jsonValue(value)
+		}
+		msg = &safe
+	}
+	bytes, _ = json.Marshal(msg)
+	return bytes
+}
+
+func minDuration(a, b time.Duration) time.Duration {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,187 @@
+package socketlogger
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTCPReconnect(t *testing.T) {
+	dir := t.TempDir()
+	server := Connection{
+		Addr: "127.0.0.1",
+		Port: freePort(t),
+	}
+
+	statuses := make(chan ConnectionStatus, 10)
+	logger := NewTcpLoggerClient()
+	logger.SetReconnect(10*time.Millisecond, 100*time.Millisecond, 100)
+	logger.SetStatusCallback(func(status ConnectionStatus, err error) {
+		statuses <- status
+	})
+	if err := logger.Connect(Connection{}, server); err != nil {
+		t.Fatalf("Connect should not fail when the server is down: %v", err)
+	}
+	if logger.Status() != StatusConnecting {
+		t.Errorf("Expected status %v, actual %v", StatusConnecting, logger.Status())
+	}
+	logger.Log("sent before the server started")
+
+	first := NewTcpLoggerServer()
+	first.SetLogFile(dir, "reconnect.log")
+	first.Bind(server)
+	waitForStatus(t, statuses, StatusConnected)
+	logger.Log("sent while connected")
+	waitForLog(t, filepath.Join(dir, "reconnect.log"), "sent while connected")
+	first.Shutdown()
+
+	waitForStatus(t, statuses, StatusConnecting)
+	logger.Log("sent while the server was down")
+
+	second := NewTcpLoggerServer()
+	second.SetLogFile(dir, "reconnect.log")
+	buffered := make(map[string]bool)
+	second.AddSink(SinkFunc(func(msg SocketMessage) error {
+		if inst := msg.(*LogMessage); inst.Remote != "" {
+			buffered[inst.Message] = inst.Buffered
+		}
+		return nil
+	}))
+	second.Bind(server)
+	waitForStatus(t, statuses, StatusConnected)
+	logger.Log("sent after reconnecting")
+
+	logger.Disconnect()
+	second.Shutdown()
+
+	assertLogContains(t, filepath.Join(dir, "reconnect.log"), "sent before the server started", "sent while connected", "sent while the server was down", "reconnected at")
+	if !buffered["sent while the server was down"] || buffered["sent after reconnecting"] {
+		t.Errorf("Expected only the message held while disconnected to be marked buffered: %v", buffered)
+	}
+}
+
+func TestUnsentStatus(t *testing.T) {
+	errs := make(chan error, 10)
+	logger := NewTcpLoggerClient()
+	logger.SetStatusCallback(func(status ConnectionStatus, err error) {
+		if status == StatusDisconnected {
+			errs <- err
+		}
+	})
+	logger.Connect(Connection{}, Connection{Addr: "127.0.0.1", Port: freePort(t)})
+	logger.Log("never sent")
+	logger.Disconnect()
+	if err := <-errs; err == nil || !strings.Contains(err.Error(), "1 unsent messages") {
+		t.Errorf("The status callback should get the unsent messages, got %v", err)
+	}
+}
+
+func TestSpoolSurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+	spoolFile := filepath.Join(dir, "spool", "csv.spool")
+	server := Connection{
+		Addr: "127.0.0.1",
+		Port: freePort(t),
+	}
+
+	// Server is down for the whole life of the first client
+	before := NewTcpCsvClient()
+	if err := before.SetSpoolFile(spoolFile); err != nil {
+		t.Fatal(err)
+	}
+	before.Connect(Connection{}, server)
+	before.NewCsvFile("spooled.csv", []interface{}{"run", "value"})
+	before.AppendRow("spooled.csv", []interface{}{1, 3.5})
+	before.Disconnect()
+
+	if info, err := os.Stat(spoolFile); err != nil || info.Size() == 0 {
+		t.Fatalf("Nothing was spooled to %s: %v", spoolFile, err)
+	}
+
+	csvServer := NewTcpCsvServer()
+	csvServer.SetOutputCsvDirectory(dir)
+	csvServer.Bind(server)
+
+	after := NewTcpCsvClient()
+	after.SetSpoolFile(spoolFile)
+	after.Connect(Connection{}, server)
+	after.AppendRow("spooled.csv", []interface{}{2, 4.5})
+	after.Disconnect()
+	csvServer.Shutdown()
+
+	dat, _ := os.ReadFile(filepath.Join(dir, "spooled.csv"))
+	if expected := "run,value\n1,3.5\n2,4.5\n"; string(dat) != expected {
+		t.Errorf("Spooled rows were not written in order. Expected %q, actual %q", expected, dat)
+	}
+	if info, _ := os.Stat(spoolFile); info.Size() != 0 {
+		t.Errorf("Spool was not drained, %d bytes left", info.Size())
+	}
+}
+
+func TestSpoolCompact(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "compact.spool")
+	s, err := openSpool(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.close()
+	for _, msg := range []string{"1", "2", "3"} {
+		s.append([]byte(msg))
+	}
+
+	failAt := func(n int) func([]byte) error {
+		return func(msg []byte) error {
+			if n--; n < 0 {
+				return errors.New("server went away")
+			}
+			return nil
+		}
+	}
+	s.drain(failAt(1))
+	// A rewrite that can't be made leaves the spool as it was
+	os.Mkdir(path+".tmp", os.ModePerm)
+	s.drain(failAt(0))
+	os.Remove(path + ".tmp")
+	if err := s.append([]byte("4")); err != nil {
+		t.Fatalf("Spool stopped taking messages after compacting: %v", err)
+	}
+
+	var sent []string
+	if err := s.drain(func(msg []byte) error {
+		sent = append(sent, string(msg))
+		return nil
+	}); err != nil || strings.Join(sent, ",") != "2,3,4" {
+		t.Errorf("Expected 2,3,4 to be left, actual %v (%v)", sent, err)
+	}
+}
+
+func TestBacklogDropsOldest(t *testing.T) {
+	b := newBacklog(2)
+	b.push([]byte("1"))
+	b.push([]byte("2"))
+	b.push([]byte("3"))
+
+	if b.len() != 2 || string(b.peek()) != "2" {
+		t.Errorf("Expected oldest message to be dropped, backlog starts with %s", b.peek())
+	}
+	if dropped := b.resetDropped(); dropped != 1 {
+		t.Errorf("Expected 1 dropped message, actual %d", dropped)
+	}
+}
+
+func waitForStatus(t *testing.T, statuses chan ConnectionStatus, expected ConnectionStatus) {
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case status := <-statuses:
+			if status == expected {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("Client never reached status %v", expected)
+		}
+	}
+}
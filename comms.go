@@ -1,10 +1,19 @@
 package socketlogger
 
 import (
+	"crypto/tls"
 	"net"
 )
 
 type comms struct {
 	sock               net.Conn
-	connectionProtocol string // TCP/UDP
+	connectionProtocol string      // TCP/UDP
+	tlsConfig          *tls.Config // nil unless SetTLSConfig has been called
+	framing            Framing
+}
+
+// SetTLSConfig enables TLS on the stream socket. Must be called before Bind/Connect
+func (c *comms) SetTLSConfig(config *tls.Config) error {
+	c.tlsConfig = config
+	return nil
 }
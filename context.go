@@ -0,0 +1,46 @@
+package socketlogger
+
+import (
+	"context"
+)
+
+// Fields the default context extractor sends
+const (
+	TraceIDField   string = "trace_id"
+	SpanIDField    string = "span_id"
+	RequestIDField string = "request_id"
+)
+
+type contextKey int
+
+const (
+	traceIDKey contextKey = iota
+	spanIDKey
+	requestIDKey
+)
+
+// ContextWithTrace returns a copy of ctx that carries the trace and span IDs sent by the Ctx methods
+func ContextWithTrace(ctx context.Context, traceID, spanID string) context.Context {
+	ctx = context.WithValue(ctx, traceIDKey, traceID)
+	return context.WithValue(ctx, spanIDKey, spanID)
+}
+
+// ContextWithRequestID returns a copy of ctx that carries the request ID sent by the Ctx methods
+func ContextWithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDKey, requestID)
+}
+
+// ContextFields is the default context extractor. It returns the trace, span and request IDs stored in
+// ctx by ContextWithTrace and ContextWithRequestID
+func ContextFields(ctx context.Context) Fields {
+	var fields Fields
+	for key, name := range map[contextKey]string{traceIDKey: TraceIDField, spanIDKey: SpanIDField, requestIDKey: RequestIDField} {
+		if value, ok := ctx.Value(key).(string); ok && value != "" {
+			if fields == nil {
+				fields = make(Fields, 3)
+			}
+			fields[name] = value
+		}
+	}
+	return fields
+}
@@ -0,0 +1,111 @@
+package socketlogger
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestContextFields(t *testing.T) {
+	ctx := ContextWithRequestID(ContextWithTrace(context.Background(), "4bf92f35", "00f067aa"), "req-1")
+	fields := ContextFields(ctx)
+	if len(fields) != 3 || fields[TraceIDField] != "4bf92f35" || fields[SpanIDField] != "00f067aa" || fields[RequestIDField] != "req-1" {
+		t.Errorf("Unexpected fields %v", fields)
+	}
+	if fields := ContextFields(context.Background()); fields != nil {
+		t.Errorf("Expected no fields, actual %v", fields)
+	}
+}
+
+func TestContextLogging(t *testing.T) {
+	server, path, remote := startLoggerServer(t)
+	logger := connectLogger(t, remote)
+	ctx := ContextWithTrace(context.Background(), "4bf92f35", "00f067aa")
+	_, _, line, _ := runtime.Caller(0)
+	logger.ErrCtx(ctx, "upload failed after %d tries", 3)
+	logger.WithContext(ContextWithRequestID(ctx, "req-1")).Logw("bound", "n", 1)
+
+	type tenantKey struct{}
+	logger.SetContextExtractor(func(ctx context.Context) Fields {
+		return Fields{"tenant": ctx.Value(tenantKey{})}
+	})
+	logger.LogCtx(context.WithValue(ctx, tenantKey{}, "acme"), "custom extractor")
+	logger.Disconnect()
+	server.Shutdown()
+
+	assertLogContains(t, path,
+		string(red)+fmt.Sprintf(" | context_test.go:%d -- upload failed after 3 tries span_id=00f067aa trace_id=4bf92f35", line+1),
+		"-- bound n=1 request_id=req-1 span_id=00f067aa trace_id=4bf92f35",
+		"-- custom extractor tenant=acme"+string(reset),
+	)
+}
+
+func TestLiveTailTrace(t *testing.T) {
+	tail := NewLiveTail()
+	if err := tail.SetHistory(-1); err == nil {
+		t.Error("Negative history was accepted")
+	}
+	tail.SetHistory(100)
+	tailAddr := bindLocal(t, tail)
+	defer tail.Shutdown()
+
+	server := NewTcpLoggerServer()
+	server.SetLogFile(t.TempDir(), "trace.log")
+	server.SetLiveTail(tail)
+	remote := bindLocal(t, server)
+	defer server.Shutdown()
+
+	// Two processes handling parts of the same request, logged before anyone is watching
+	ctx := ContextWithTrace(context.Background(), "4bf92f35", "00f067aa")
+	for _, step := range []string{"frontend", "backend"} {
+		logger := connectLogger(t, remote)
+		logger.LogCtx(ctx, "%s handled the request", step)
+		logger.LogCtx(ContextWithTrace(context.Background(), "other", "1"), "%s handled another request", step)
+		logger.Disconnect()
+	}
+	waitFor(t, "the last message in the tail", func() bool {
+		tail.lock.Lock()
+		defer tail.lock.Unlock()
+		for _, record := range tail.history {
+			if record.msg.Message == "backend handled another request" {
+				return true
+			}
+		}
+		return false
+	})
+
+	resp, err := http.Get(fmt.Sprintf("http://127.0.0.1:%d/events?trace=4bf92f35", tailAddr.Port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	events := make(chan tailEvent)
+	go func() {
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			if data := strings.TrimPrefix(scanner.Text(), "data: "); data != scanner.Text() {
+				var event tailEvent
+				json.Unmarshal([]byte(data), &event)
+				events <- event
+			}
+		}
+	}()
+
+	for _, expected := range []string{"frontend handled the request", "backend handled the request"} {
+		select {
+		case event := <-events:
+			if event.Message != expected || event.Fields[TraceIDField] != "4bf92f35" {
+				t.Errorf("Expected %q, actual %+v", expected, event)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Timed out waiting for %q", expected)
+		}
+	}
+}
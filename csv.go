@@ -3,21 +3,30 @@ package socketlogger
 import (
 	"encoding/csv"
 	"fmt"
-	"log"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 )
 
 type CsvServer interface {
 	SetOutputCsvDirectory(string)
+	SetOutput(w io.Writer)
+	SetUpstream(client CsvClient)
+	Reopen() error
 	Server
 }
 
 type csvserver struct {
 	writers   map[string]*csv.Writer
+	files     map[string]*os.File // Under the writers, by the same file names
+	headers   map[string][]string // From NewCsvFile, by the same file names
+	mu        sync.Mutex          // Held while a row is written, so Reopen doesn't swap files under it
 	outputDir string
 	flush     chan bool
+	upstream  *upstream // nil unless SetUpstream has been called
+	status    *TextSink // The server's own messages, see statusSink()
 }
 
 func (c *csvserver) SetOutputCsvDirectory(dir string) {
@@ -25,11 +34,34 @@ func (c *csvserver) SetOutputCsvDirectory(dir string) {
 	if !fileDirExists(dir, "") {
 		err := os.MkdirAll(dir, os.ModePerm)
 		if err != nil {
-			log.Print(newLogMessage(MessageLevelWrn, "Could not make directory \"%s\", %v", dir, err))
+			c.statusSink().Write(newLogMessage(MessageLevelWrn, "Could not make directory \"%s\", %v", dir, err))
 		}
 	}
 }
 
+// SetOutput writes the server's own messages, like files created and clients disconnecting, to w
+// instead of the console. Each server has its own output, the log package's is left alone
+func (c *csvserver) SetOutput(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.statusSink().SetOutput(w)
+}
+
+// Created on first use, so servers made without their constructor still have an output
+func (c *csvserver) statusSink() *TextSink {
+	if c.status == nil {
+		c.status = NewConsoleSink()
+	}
+	return c.status
+}
+
+// SetUpstream re-sends every row this server writes to another csv server, with the name of this
+// machine added. The upstream server keeps the files of every host in their own directory. The client
+// must be connected before Bind and disconnected after Shutdown
+func (c *csvserver) SetUpstream(client CsvClient) {
+	c.upstream = newUpstream(client)
+}
+
 func (c *csvserver) buildCsvFile(msg *CsvMessage) *csv.Writer {
 	if !fileDirExists(c.outputDir, msg.Filename) ||
 		(fileDirExists(c.outputDir, msg.Filename) && c.writers[msg.Filename] == nil) {
@@ -45,56 +77,113 @@ func (c *csvserver) buildCsvFile(msg *CsvMessage) *csv.Writer {
 		}
 
 		if duplicate {
-			log.Print(newLogMessage(MessageLevelWrn, "Found previous %s, creating %s", msg.Filename, fname))
+			c.statusSink().Write(newLogMessage(MessageLevelWrn, "Found previous %s, creating %s", msg.Filename, fname))
 		}
 
+		os.MkdirAll(filepath.Dir(fname), os.ModePerm) // Relayed files are in a directory per host
 		fptr, err := os.OpenFile(fname, os.O_CREATE|os.O_APPEND|os.O_RDWR, 0o666)
 
 		if err != nil {
-			log.Print(newLogMessage(MessageLevelErr, "Could not open file: %s -> %v", fname, err))
+			c.statusSink().Write(newLogMessage(MessageLevelErr, "Could not open file: %s -> %v", fname, err))
 			return nil
 		} else {
-			log.Print(newLogMessage(MessageLevelSuccess, "File created %s", fname))
+			c.statusSink().Write(newLogMessage(MessageLevelSuccess, "File created %s", fname))
 		}
 
 		csvWriter := csv.NewWriter(fptr)
-		if err != nil {
-			log.Print(newLogMessage(MessageLevelErr, "Could not open build csv.Writer -> %v", err))
-			return nil
+		if previous := c.files[msg.Filename]; previous != nil {
+			previous.Close() // Moved or removed since it was opened
+			if header := c.headers[msg.Filename]; header != nil && !msg.Header {
+				csvWriter.Write(header)
+				csvWriter.Flush()
+			}
 		}
 		c.writers[msg.Filename] = csvWriter
+		c.files[msg.Filename] = fptr
 	}
 
 	return c.writers[msg.Filename]
 }
 
+// Reopen opens every csv file again by its path, for logrotate. Rows that arrive meanwhile wait and
+// are written to the new files, which start with the header again if they are empty
+func (c *csvserver) Reopen() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var err error
+	for name, file := range c.files {
+		fptr, openErr := os.OpenFile(file.Name(), os.O_CREATE|os.O_APPEND|os.O_RDWR, 0o666)
+		if openErr != nil {
+			if err == nil {
+				err = openErr
+			}
+			continue
+		}
+		file.Close()
+		writer := csv.NewWriter(fptr)
+		if info, statErr := fptr.Stat(); statErr == nil && info.Size() == 0 && c.headers[name] != nil {
+			writer.Write(c.headers[name])
+			writer.Flush()
+		}
+		c.files[name] = fptr
+		c.writers[name] = writer
+	}
+	return err
+}
+
 func (c *csvserver) getMessageType() SocketMessage {
 	return &CsvMessage{}
 }
 
 func (c *csvserver) initCsvServer() {
 	c.writers = make(map[string]*csv.Writer)
+	c.files = make(map[string]*os.File)
+	c.headers = make(map[string][]string)
 }
 
 func (c *csvserver) write(msgs chan SocketMessage) {
 	for msg := range msgs {
-		if msg.Type() == Csv {
-			inst := msg.(*CsvMessage)
-			if inst.Filename != "" {
-				writer := c.buildCsvFile(inst)
-				if writer == nil {
-					log.Print(newLogMessage(MessageLevelErr, "csv writer returned as nil!"))
-					continue
-				}
-				// Only need to write the row if it is there
-				if len(inst.Row) > 0 {
-					writer.Write(transform(inst.Row))
-					writer.Flush() // flushes headers & data
-				}
+		c.mu.Lock()
+		c.writeMsg(msg)
+		c.mu.Unlock()
+	}
+	c.mu.Lock()
+	for _, file := range c.files {
+		file.Close()
+	}
+	c.mu.Unlock()
+	c.flush <- true
+}
+
+func (c *csvserver) writeMsg(msg SocketMessage) {
+	if msg.Type() == Csv {
+		inst := msg.(*CsvMessage)
+		if inst.Filename != "" && c.upstream != nil {
+			c.upstream.send(inst)
+		}
+		if inst.Filename != "" && inst.Host != "" {
+			relayed := *inst
+			relayed.Filename = filepath.Join(hostDir(inst.Host), inst.Filename)
+			inst = &relayed
+		}
+		if inst.Filename != "" && inst.Header {
+			c.headers[inst.Filename] = transform(inst.Row)
+		}
+		if inst.Filename != "" {
+			writer := c.buildCsvFile(inst)
+			if writer == nil {
+				c.statusSink().Write(newLogMessage(MessageLevelErr, "csv writer returned as nil!"))
+				return
+			}
+			// Only need to write the row if it is there
+			if len(inst.Row) > 0 {
+				writer.Write(transform(inst.Row))
+				writer.Flush() // flushes headers & data
 			}
 		}
+	} else if msg.Type() == Log {
+		c.statusSink().Write(msg) // Server status, e.g. a client disconnected or sent a bad message
 	}
-	c.flush <- true
 }
 
 func (c *csvserver) setFlushChannel(flush chan bool) {
@@ -125,6 +214,45 @@ func NewUdpCsvServer() CsvServer {
 	return u
 }
 
+type UnixCsvServer struct {
+	unixserver
+	csvserver
+}
+
+// NewUnixCsvServer listens for stream connections on Connection.Path
+func NewUnixCsvServer() CsvServer {
+	u := &UnixCsvServer{}
+	u.init(u)
+	u.initCsvServer()
+	return u
+}
+
+type UnixgramCsvServer struct {
+	unixgramserver
+	csvserver
+}
+
+// NewUnixgramCsvServer listens for datagrams on Connection.Path
+func NewUnixgramCsvServer() CsvServer {
+	u := &UnixgramCsvServer{}
+	u.init(u)
+	u.initCsvServer()
+	return u
+}
+
+type HttpCsvServer struct {
+	httpserver
+	csvserver
+}
+
+// NewHttpCsvServer accepts CsvMessages POSTed as JSON, one per request or an array of them
+func NewHttpCsvServer() CsvServer {
+	h := &HttpCsvServer{}
+	h.init(h)
+	h.initCsvServer()
+	return h
+}
+
 type CsvClient interface {
 	NewCsvFile(fname string, headers []interface{})
 	AppendRow(fname string, row []interface{})
@@ -140,7 +268,9 @@ func (c *csvclient) setMsgChannel(msgsToSend chan SocketMessage) {
 }
 
 func (c *csvclient) NewCsvFile(fname string, headers []interface{}) {
-	c.msgsToSend <- newCsvMessage(fname, headers)
+	msg := newCsvMessage(fname, headers).(*CsvMessage)
+	msg.Header = true
+	c.msgsToSend <- msg
 }
 
 func (c *csvclient) AppendRow(fname string, row []interface{}) {
@@ -168,3 +298,25 @@ func NewTcpCsvClient() CsvClient {
 	t.init(t)
 	return t
 }
+
+type UnixCsvClient struct {
+	csvclient
+	unixClient
+}
+
+func NewUnixCsvClient() CsvClient {
+	u := &UnixCsvClient{}
+	u.init(u)
+	return u
+}
+
+type UnixgramCsvClient struct {
+	csvclient
+	unixgramClient
+}
+
+func NewUnixgramCsvClient() CsvClient {
+	u := &UnixgramCsvClient{}
+	u.init(u)
+	return u
+}
@@ -1,10 +1,12 @@
 package socketlogger
 
 import (
+	"bytes"
 	"encoding/csv"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 )
@@ -294,6 +296,31 @@ func TestBadCsvFile(t *testing.T) {
 	}
 }
 
+func TestCsvServerOutput(t *testing.T) {
+	var first, second bytes.Buffer
+	servers := []CsvServer{NewTcpCsvServer(), NewTcpCsvServer()}
+	servers[0].SetOutput(&first)
+	servers[1].SetOutput(&second)
+	client := NewTcpCsvClient()
+	for _, server := range servers {
+		server.SetOutputCsvDirectory(t.TempDir())
+	}
+	client.Connect(Connection{}, bindLocal(t, servers[0]))
+	bindLocal(t, servers[1])
+	client.AppendRow("first.csv", []interface{}{1, 2})
+	client.Disconnect()
+	for _, server := range servers {
+		server.Shutdown()
+	}
+
+	if !strings.Contains(first.String(), "first.csv") {
+		t.Errorf("The first server should report creating first.csv:\n%s", first.String())
+	}
+	if strings.Contains(second.String(), "first.csv") {
+		t.Errorf("The second server should only write its own messages:\n%s", second.String())
+	}
+}
+
 func createFile(path string) {
 	os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_RDWR, 0o666)
 }
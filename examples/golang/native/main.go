@@ -15,6 +15,8 @@ func main() {
 		Addr: "127.0.0.1",
 		Port: 40000,
 	})
+	// Lines from ErrorLogger below are sent as errors
+	logger.AddLevelPrefix("ERROR: ", socketlogger.MessageLevelErr)
 	log.SetOutput(logger)
 	log.SetFlags(socketlogger.NativeFlags)
 
@@ -0,0 +1,94 @@
+package socketlogger
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Structured key/value attributes of a log message. Sent as a JSON object and written as key=value
+// after the message
+type Fields map[string]interface{}
+
+const (
+	badKey   string = "!BADKEY"   // Key for a value without one, the same as log/slog
+	badValue string = "!BADVALUE" // Marks values JSON can't hold, like channels or NaN
+)
+
+// Builds Fields from alternating keys and values. Keys that aren't strings are formatted, a value left
+// over at the end is kept under !BADKEY. Errors are kept as their text, like log/slog does
+func fieldsOf(keysAndValues []interface{}) Fields {
+	if len(keysAndValues) == 0 {
+		return nil
+	}
+	fields := make(Fields, (len(keysAndValues)+1)/2)
+	for i := 0; i < len(keysAndValues); i += 2 {
+		if i+1 == len(keysAndValues) {
+			fields[badKey] = fieldValue(keysAndValues[i])
+			break
+		}
+		key, ok := keysAndValues[i].(string)
+		if !ok {
+			key = fmt.Sprint(keysAndValues[i])
+		}
+		fields[key] = fieldValue(keysAndValues[i+1])
+	}
+	return fields
+}
+
+// Most errors marshal to {}
+func fieldValue(value interface{}) interface{} {
+	if err, ok := value.(error); ok {
+		return err.Error()
+	}
+	return value
+}
+
+// Replaces values JSON can't hold with their formatted text, marked !BADVALUE, so the rest of the
+// message is still sent
+func jsonValue(value interface{}) interface{} {
+	if _, err := json.Marshal(value); err != nil {
+		return fmt.Sprintf("%s(%v)", badValue, value)
+	}
+	return value
+}
+
+// Sorted, so the same fields are always written the same way
+func (f Fields) keys() []string {
+	keys := make([]string, 0, len(f))
+	for key := range f {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// logfmt
+func (f Fields) String() string {
+	keys := f.keys()
+	pairs := make([]string, len(keys))
+	for i, key := range keys {
+		pairs[i] = logfmtValue(key) + "=" + logfmtValue(fmt.Sprint(f[key]))
+	}
+	return strings.Join(pairs, " ")
+}
+
+// Replaces every rune allowed returns false for with '_', for formats that restrict field names
+func fieldName(key string, allowed func(r rune) bool) string {
+	return strings.Map(func(r rune) rune {
+		if allowed(r) {
+			return r
+		}
+		return '_'
+	}, key)
+}
+
+// Quotes values that would otherwise be ambiguous
+func logfmtValue(value string) string {
+	if value == "" || strings.ContainsAny(value, " =\"\\\t\r\n") {
+		return strconv.Quote(value)
+	}
+	return value
+}
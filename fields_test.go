@@ -0,0 +1,51 @@
+package socketlogger
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestFieldsOf(t *testing.T) {
+	fields := fieldsOf([]interface{}{"camera", 2, 7, "seven", "left over"})
+	if len(fields) != 3 || fields["camera"] != 2 || fields["7"] != "seven" || fields[badKey] != "left over" {
+		t.Errorf("Unexpected fields %v", fields)
+	}
+	if fields := fieldsOf([]interface{}{"err", errors.New("disk full")}); fields["err"] != "disk full" {
+		t.Errorf("Errors should be kept as their text: %v", fields)
+	}
+	if fieldsOf(nil) != nil {
+		t.Error("No pairs should give no fields")
+	}
+}
+
+func TestFieldsString(t *testing.T) {
+	fields := Fields{"queue": 31, "path": "/var/log", "note": `said "hi"`, "empty": "", "spaced key": true}
+	if expected := `empty="" note="said \"hi\"" path=/var/log queue=31 "spaced key"=true`; fields.String() != expected {
+		t.Errorf("Expected %s, actual %s", expected, fields.String())
+	}
+}
+
+func TestStructuredFields(t *testing.T) {
+	server, path, remote := startLoggerServer(t)
+	logger := connectLogger(t, remote)
+	caller := callerAt(1)
+	logger.Wrnw("frame dropped", "camera", 2, "queue", 31)
+	logger.With("err", errors.New("disk full")).Errw("save failed", "ratio", math.NaN())
+	logger.Disconnect()
+	server.Shutdown()
+
+	assertLogContains(t, path, caller+" -- frame dropped camera=2 queue=31", `-- save failed err="disk full" ratio=!BADVALUE(NaN)`)
+
+	wire, _ := json.Marshal(newLogMessageCaller(MessageLevelLog, "x.go", 1, true, "plain").(*LogMessage))
+	if strings.Contains(string(wire), `"fields"`) {
+		t.Errorf("Fields should be left out when there are none: %s", wire)
+	}
+	var decoded LogMessage
+	json.Unmarshal([]byte(`{"caller":"x.go:1","level":0,"message":"m","fields":{"camera":2,"ok":true}}`), &decoded)
+	if decoded.Fields["camera"] != 2.0 || decoded.Fields["ok"] != true {
+		t.Errorf("Fields should be a JSON object: %v", decoded.Fields)
+	}
+}
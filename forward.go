@@ -0,0 +1,46 @@
+package socketlogger
+
+import (
+	"fmt"
+	"os"
+)
+
+// Common to the clients that forward logger server messages to other log systems (syslog, GELF).
+// Embedded next to a transport client and the encoder for the format
+type forwardclient struct {
+	msgsToSend chan SocketMessage
+	hostname   string
+}
+
+func (f *forwardclient) initForwardClient() {
+	f.hostname, _ = os.Hostname()
+}
+
+// SetHostname sets the host name messages are sent with, the name of this machine by default.
+// Must be called before Connect
+func (f *forwardclient) SetHostname(name string) {
+	f.hostname = name
+}
+
+// SetFraming is not used, the format has its own framing
+func (f *forwardclient) SetFraming(framing Framing) error {
+	return fmt.Errorf("framing is not supported by the forwarding clients")
+}
+
+func (f *forwardclient) setMsgChannel(msgsToSend chan SocketMessage) {
+	f.msgsToSend = msgsToSend
+}
+
+// Called by the logger server writer. The message is copied because the client stamps its own envelope on it
+func (f *forwardclient) publish(msg *LogMessage) {
+	forward := *msg
+	f.msgsToSend <- &forward
+}
+
+// Messages relayed from another machine keep the name of the machine they came from
+func (f *forwardclient) host(msg *LogMessage) string {
+	if msg.Host != "" {
+		return msg.Host
+	}
+	return f.hostname
+}
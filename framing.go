@@ -0,0 +1,277 @@
+package socketlogger
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+type Framing int
+
+const (
+	// Back to back JSON objects, the original wire format. A malformed message ends the connection
+	FramingStream Framing = 0
+	// One JSON message per line
+	FramingNewline Framing = 1
+	// 4 byte big endian length followed by the JSON message
+	FramingLength Framing = 2
+
+	// Used internally by the syslog servers: the whole datagram is one message, or RFC 6587
+	// octet counting / newline delimited messages on a stream
+	framingDatagram Framing = -1
+	framingSyslog   Framing = -2
+	// Used internally by GELF: messages end with a null byte on a stream, and are split into
+	// chunks when they don't fit in one datagram
+	framingNull        Framing = -3
+	framingGelfChunked Framing = -4
+
+	maxFrameSize int = 1 << 20
+)
+
+var framingNames = map[Framing]string{
+	FramingStream:  "stream",
+	FramingNewline: "newline",
+	FramingLength:  "length",
+}
+
+func (f Framing) String() string {
+	if name, ok := framingNames[f]; ok {
+		return name
+	}
+	return fmt.Sprintf("Framing(%d)", int(f))
+}
+
+// ParseFraming returns the Framing for "stream", "newline" or "length"
+func ParseFraming(name string) (Framing, error) {
+	for framing, n := range framingNames {
+		if n == name {
+			return framing, nil
+		}
+	}
+	return FramingStream, fmt.Errorf("unknown framing %q", name)
+}
+
+// SetFraming sets how messages are delimited on the wire. Servers and clients must use the same framing
+func (c *comms) SetFraming(framing Framing) error {
+	if _, ok := framingNames[framing]; !ok {
+		return fmt.Errorf("unknown framing %v", framing)
+	}
+	c.framing = framing
+	return nil
+}
+
+// Wraps a marshalled message for the wire
+func encodeFrame(msg []byte, framing Framing) []byte {
+	switch framing {
+	case FramingNewline:
+		return append(msg[:len(msg):len(msg)], '\n')
+	case FramingLength:
+		frame := make([]byte, 4, 4+len(msg))
+		binary.BigEndian.PutUint32(frame, uint32(len(msg)))
+		return append(frame, msg...)
+	case framingSyslog:
+		return append([]byte(fmt.Sprintf("%d ", len(msg))), msg...)
+	case framingNull:
+		return append(msg[:len(msg):len(msg)], 0)
+	}
+	return msg
+}
+
+type frameReader interface {
+	// Returns the next message, a badFrame error if it could not be read but the
+	// stream is still usable, any other error when nothing more can be read
+	next() ([]byte, error)
+}
+
+type badFrame struct {
+	err error
+}
+
+func (b badFrame) Error() string {
+	return b.err.Error()
+}
+
+func newFrameReader(r io.Reader, framing Framing) frameReader {
+	return framesOf(bufio.NewReaderSize(r, bufSize), framing)
+}
+
+func framesOf(reader *bufio.Reader, framing Framing) frameReader {
+	switch framing {
+	case FramingNewline:
+		return &lineFrames{reader: reader, delim: '\n'}
+	case FramingLength:
+		return &lengthFrames{reader: reader}
+	case framingDatagram:
+		return &datagramFrames{reader: reader}
+	case framingSyslog:
+		return &syslogFrames{reader: reader, lines: &lineFrames{reader: reader, delim: '\n'}}
+	case framingNull:
+		return &lineFrames{reader: reader, delim: 0}
+	}
+	return &streamFrames{dec: json.NewDecoder(reader)}
+}
+
+// Reads the frames of one datagram after another through the same buffer
+type datagramSplitter struct {
+	data    *bytes.Reader
+	reader  *bufio.Reader
+	framing Framing
+}
+
+func newDatagramSplitter(framing Framing) *datagramSplitter {
+	data := bytes.NewReader(nil)
+	return &datagramSplitter{
+		data:    data,
+		reader:  bufio.NewReaderSize(data, bufSize),
+		framing: framing,
+	}
+}
+
+// The frames of datagram, only valid until frames is called again
+func (d *datagramSplitter) frames(datagram []byte) frameReader {
+	d.data.Reset(datagram)
+	d.reader.Reset(d.data)
+	return framesOf(d.reader, d.framing)
+}
+
+type streamFrames struct {
+	dec *json.Decoder
+}
+
+// The decoder can't find the start of the next message after a syntax error, so every error is fatal
+func (s *streamFrames) next() ([]byte, error) {
+	var raw json.RawMessage
+	err := s.dec.Decode(&raw)
+	return raw, err
+}
+
+type lineFrames struct {
+	reader *bufio.Reader
+	delim  byte
+}
+
+func (l *lineFrames) next() ([]byte, error) {
+	var frame []byte
+	tooLarge := false
+	for {
+		chunk, err := l.reader.ReadSlice(l.delim)
+		if !tooLarge {
+			if len(frame)+len(chunk) > maxFrameSize {
+				tooLarge = true
+				frame = nil
+			} else {
+				frame = append(frame, chunk...)
+			}
+		}
+
+		if err == bufio.ErrBufferFull {
+			continue
+		} else if err == io.EOF && !tooLarge && len(l.trim(frame)) > 0 {
+			return l.trim(frame), nil // Last message without a newline
+		} else if err != nil {
+			return nil, err
+		}
+
+		if tooLarge {
+			return nil, badFrame{fmt.Errorf("message is larger than %d bytes", maxFrameSize)}
+		}
+		if frame = l.trim(frame); len(frame) > 0 {
+			return frame, nil
+		}
+	}
+}
+
+// Drops the delimiter, and whitespace that senders often add around the message
+func (l *lineFrames) trim(frame []byte) []byte {
+	return bytes.TrimSpace(bytes.TrimSuffix(frame, []byte{l.delim}))
+}
+
+type lengthFrames struct {
+	reader *bufio.Reader
+}
+
+func (l *lengthFrames) next() ([]byte, error) {
+	var header [4]byte
+	for {
+		if _, err := io.ReadFull(l.reader, header[:]); err != nil {
+			return nil, err
+		}
+
+		// Nothing after a bad length can be trusted, so it is fatal
+		size := binary.BigEndian.Uint32(header[:])
+		if size > uint32(maxFrameSize) {
+			return nil, fmt.Errorf("frame length %d is larger than %d bytes", size, maxFrameSize)
+		} else if size == 0 {
+			continue
+		}
+
+		frame := make([]byte, size)
+		_, err := io.ReadFull(l.reader, frame)
+		return frame, err
+	}
+}
+
+type datagramFrames struct {
+	reader io.Reader
+	done   bool
+}
+
+func (d *datagramFrames) next() ([]byte, error) {
+	if d.done {
+		return nil, io.EOF
+	}
+	d.done = true
+	frame, err := io.ReadAll(d.reader) // Not trimmed, the datagram may be compressed
+	if err != nil {
+		return nil, err
+	} else if len(frame) == 0 {
+		return nil, io.EOF
+	}
+	return frame, nil
+}
+
+// RFC 6587: a message either starts with its length and a space, or ends at a newline. Senders
+// can mix both on one connection
+type syslogFrames struct {
+	reader *bufio.Reader
+	lines  *lineFrames
+}
+
+func (s *syslogFrames) next() ([]byte, error) {
+	for {
+		first, err := s.reader.Peek(1)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case first[0] == '\n' || first[0] == '\r' || first[0] == ' ':
+			s.reader.ReadByte()
+			continue
+		case first[0] < '0' || first[0] > '9':
+			return s.lines.next()
+		}
+
+		// Nothing after a bad length can be trusted, so it is fatal
+		size := 0
+		for digits := 0; ; digits++ {
+			b, err := s.reader.ReadByte()
+			if err != nil {
+				return nil, err
+			} else if b == ' ' {
+				break
+			} else if b < '0' || b > '9' || digits == 7 {
+				return nil, fmt.Errorf("bad octet count")
+			}
+			size = size*10 + int(b-'0')
+		}
+		if size > maxFrameSize {
+			return nil, fmt.Errorf("frame length %d is larger than %d bytes", size, maxFrameSize)
+		}
+		frame := make([]byte, size)
+		_, err = io.ReadFull(s.reader, frame)
+		return frame, err
+	}
+}
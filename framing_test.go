@@ -0,0 +1,94 @@
+package socketlogger
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFrameRoundTrip(t *testing.T) {
+	msgs := []string{`{"caller":"a.go:1","level":0,"message":"one"}`, `{"caller":"b.go:2","level":3,"message":"two"}`}
+	for _, framing := range []Framing{FramingStream, FramingNewline, FramingLength} {
+		var wire bytes.Buffer
+		for _, msg := range msgs {
+			wire.Write(encodeFrame([]byte(msg), framing))
+		}
+
+		frames := newFrameReader(&wire, framing)
+		for _, expected := range msgs {
+			frame, err := frames.next()
+			if err != nil || string(frame) != expected {
+				t.Errorf("%v: expected %s, actual %s (%v)", framing, expected, frame, err)
+			}
+		}
+		if _, err := frames.next(); err != io.EOF {
+			t.Errorf("%v: expected EOF, actual %v", framing, err)
+		}
+	}
+}
+
+// Nothing left unread in one datagram shows up in the next
+func TestDatagramSplitter(t *testing.T) {
+	splitter := newDatagramSplitter(FramingNewline)
+	frames := splitter.frames([]byte(`{"message":"one"}` + "\n" + `{"message":"unread"}` + "\n"))
+	if frame, err := frames.next(); string(frame) != `{"message":"one"}` {
+		t.Errorf("Unexpected first frame %s (%v)", frame, err)
+	}
+
+	frames = splitter.frames([]byte(`{"message":"two"}` + "\n"))
+	if frame, err := frames.next(); string(frame) != `{"message":"two"}` {
+		t.Errorf("Unexpected frame of the next datagram %s (%v)", frame, err)
+	}
+	if _, err := frames.next(); err != io.EOF {
+		t.Errorf("Expected EOF, actual %v", err)
+	}
+}
+
+func TestNewlineFrameTooLarge(t *testing.T) {
+	wire := strings.Repeat("x", maxFrameSize+1) + "\n" + `{"message":"after"}` + "\n"
+	frames := newFrameReader(strings.NewReader(wire), FramingNewline)
+
+	if _, err := frames.next(); err == nil {
+		t.Error("Oversized line was not rejected")
+	} else if _, ok := err.(badFrame); !ok {
+		t.Errorf("Oversized line should be skippable, actual error %v", err)
+	}
+	if frame, err := frames.next(); string(frame) != `{"message":"after"}` {
+		t.Errorf("Message after the oversized line was lost: %s (%v)", frame, err)
+	}
+}
+
+func TestParseFraming(t *testing.T) {
+	for _, framing := range []Framing{FramingStream, FramingNewline, FramingLength} {
+		if parsed, err := ParseFraming(framing.String()); err != nil || parsed != framing {
+			t.Errorf("Expected %v, actual %v (%v)", framing, parsed, err)
+		}
+	}
+	if _, err := ParseFraming("xml"); err == nil {
+		t.Error("Unknown framing was accepted")
+	}
+}
+
+func TestMalformedMessageSkipped(t *testing.T) {
+	dir := t.TempDir()
+	server := NewTcpLoggerServer()
+	server.SetLogFile(dir, "framing.log")
+	server.SetFraming(FramingNewline)
+	remote := bindLocal(t, server)
+
+	conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", remote.Port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Write([]byte(`{"caller":"framing_test.go","level":0,"message":"before the bad frame"}` + "\n"))
+	conn.Write([]byte(`{"caller": this is not json` + "\n"))
+	conn.Write([]byte(`{"caller":"framing_test.go","level":0,"message":"after the bad frame"}` + "\n"))
+	conn.Close()
+	server.Shutdown()
+
+	assertLogContains(t, filepath.Join(dir, "framing.log"), "before the bad frame", "Skipping malformed message", "after the bad frame", "Socket disconnected")
+}
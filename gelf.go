@@ -0,0 +1,312 @@
+package socketlogger
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+	"unicode"
+)
+
+const (
+	gelfChunkSize    int           = 8192 // Largest datagram the client sends, header included
+	gelfChunkHeader  int           = 12   // Magic, message ID, sequence number, sequence count
+	gelfMaxChunks    int           = 128
+	gelfChunkTimeout time.Duration = 5 * time.Second // Incomplete messages are dropped after this
+	gelfMaxPending   int           = 1000            // Incomplete messages held at once
+	gelfMaxMessage   int           = 8 << 20         // Largest message after decompressing
+)
+
+var gelfMagic = []byte{0x1e, 0x0f}
+
+// Parses GELF messages instead of socketlogger JSON. Embedded next to a transport server and loggerserver
+type gelfserver struct {
+	lock     sync.Mutex
+	partials map[string]*gelfPartial // Chunked messages still being received, by message ID
+}
+
+type gelfPartial struct {
+	chunks   [][]byte
+	received int
+	started  time.Time
+}
+
+// The GELF fields that are mapped onto LogMessage
+type gelfMessage struct {
+	Host         string      `json:"host"`
+	ShortMessage string      `json:"short_message"`
+	FullMessage  string      `json:"full_message"`
+	Level        *int        `json:"level"`
+	File         string      `json:"file"`
+	Line         interface{} `json:"line"` // Senders use both numbers and strings
+	Timestamp    *float64    `json:"timestamp"`
+}
+
+// SetFraming is not used, GELF has its own framing
+func (g *gelfserver) SetFraming(framing Framing) error {
+	return fmt.Errorf("framing is not supported by the GELF servers")
+}
+
+// Returns nil until every chunk of a chunked message has arrived
+func (g *gelfserver) decode(frame []byte) (SocketMessage, error) {
+	if bytes.HasPrefix(frame, gelfMagic) {
+		payload, err := g.reassemble(frame)
+		if payload == nil || err != nil {
+			return nil, err
+		}
+		frame = payload
+	}
+
+	payload, err := gelfDecompress(frame)
+	if err != nil {
+		return nil, err
+	}
+	return parseGelf(payload)
+}
+
+func (g *gelfserver) reassemble(chunk []byte) ([]byte, error) {
+	if len(chunk) <= gelfChunkHeader {
+		return nil, fmt.Errorf("GELF chunk is too short")
+	}
+	id, seq, count := string(chunk[2:10]), int(chunk[10]), int(chunk[11])
+	if count == 0 || count > gelfMaxChunks || seq >= count {
+		return nil, fmt.Errorf("bad GELF chunk %d of %d", seq, count)
+	}
+
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if g.partials == nil {
+		g.partials = make(map[string]*gelfPartial)
+	}
+	now := time.Now()
+	for key, partial := range g.partials {
+		if now.Sub(partial.started) > gelfChunkTimeout {
+			delete(g.partials, key)
+		}
+	}
+
+	partial, ok := g.partials[id]
+	if !ok {
+		if len(g.partials) >= gelfMaxPending {
+			return nil, fmt.Errorf("too many incomplete GELF messages")
+		}
+		partial = &gelfPartial{chunks: make([][]byte, count), started: now}
+		g.partials[id] = partial
+	} else if len(partial.chunks) != count {
+		return nil, fmt.Errorf("GELF chunk count changed from %d to %d", len(partial.chunks), count)
+	}
+	if partial.chunks[seq] == nil {
+		partial.chunks[seq] = append([]byte(nil), chunk[gelfChunkHeader:]...) // The read buffer is reused
+		partial.received++
+	}
+	if partial.received < count {
+		return nil, nil
+	}
+	delete(g.partials, id)
+	return bytes.Join(partial.chunks, nil), nil
+}
+
+// Payloads may be zlib or gzip compressed, or plain JSON
+func gelfDecompress(payload []byte) ([]byte, error) {
+	var reader io.Reader
+	var err error
+	switch {
+	case len(payload) > 1 && payload[0] == 0x1f && payload[1] == 0x8b:
+		reader, err = gzip.NewReader(bytes.NewReader(payload))
+	case len(payload) > 1 && payload[0] == 0x78 && (uint16(payload[0])<<8|uint16(payload[1]))%31 == 0:
+		reader, err = zlib.NewReader(bytes.NewReader(payload))
+	default:
+		return payload, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	decompressed, err := io.ReadAll(io.LimitReader(reader, int64(gelfMaxMessage)+1))
+	if err != nil {
+		return nil, err
+	} else if len(decompressed) > gelfMaxMessage {
+		return nil, fmt.Errorf("GELF message is larger than %d bytes", gelfMaxMessage)
+	}
+	return decompressed, nil
+}
+
+// The level is a syslog severity, alert if it is missing. The host and file become the caller
+func parseGelf(payload []byte) (*LogMessage, error) {
+	var gelf gelfMessage
+	var raw map[string]interface{}
+	if err := json.Unmarshal(payload, &gelf); err != nil {
+		return nil, err
+	} else if err := json.Unmarshal(payload, &raw); err != nil {
+		return nil, err
+	}
+	var extras Fields // Additional fields are the ones starting with _
+	for key, value := range raw {
+		if len(key) > 1 && key[0] == '_' {
+			if extras == nil {
+				extras = make(Fields)
+			}
+			extras[key[1:]] = value
+		}
+	}
+	if gelf.ShortMessage == "" && gelf.FullMessage == "" {
+		return nil, fmt.Errorf("GELF message has no short_message")
+	}
+
+	severity := 1
+	if gelf.Level != nil {
+		severity = *gelf.Level
+	}
+	if severity < 0 || severity >= len(syslogLevels) {
+		return nil, fmt.Errorf("bad GELF level %d", severity)
+	}
+
+	msg := &LogMessage{
+		LogLevel: syslogLevels[severity],
+		Caller:   gelf.Host,
+		Message:  gelf.ShortMessage,
+		Fields:   extras,
+	}
+	if gelf.File != "" {
+		file := gelf.File
+		if gelf.Line != nil {
+			file += fmt.Sprintf(":%v", gelf.Line)
+		}
+		msg.Caller = syslogCaller(gelf.Host, file, "")
+	}
+	if gelf.Timestamp != nil {
+		sent := time.Unix(0, int64(*gelf.Timestamp*1e6)*int64(time.Microsecond)) // Seconds with decimals
+		msg.Time = &sent
+	}
+	if gelf.FullMessage != "" && gelf.FullMessage != gelf.ShortMessage {
+		msg.Message = gelf.ShortMessage + "\n" + gelf.FullMessage
+	}
+	return msg, nil
+}
+
+type GelfClient interface {
+	SetHostname(name string)
+	Client
+
+	publish(msg *LogMessage)
+}
+
+// Sends messages to Graylog or anything else that accepts GELF 1.1. Embedded next to a transport client
+type gelfclient struct {
+	forwardclient
+}
+
+func (g *gelfclient) encode(msg SocketMessage) []byte {
+	entry, ok := msg.(*LogMessage)
+	if !ok {
+		entry = &LogMessage{Message: msg.String()}
+	}
+
+	severity, ok := syslogSeverities[entry.LogLevel]
+	if !ok {
+		severity = syslogSeverities[MessageLevelLog]
+	}
+	gelf := map[string]interface{}{
+		"version":       "1.1",
+		"host":          headerValue(g.host(entry)),
+		"short_message": entry.Message,
+		"timestamp":     float64(messageTime(entry).UnixNano()/int64(time.Microsecond)) / 1e6,
+		"level":         severity,
+	}
+	if entry.Message == "" {
+		gelf["short_message"] = "-" // Required to be non empty
+	}
+	if entry.Caller != "" {
+		gelf["_caller"] = entry.Caller
+	}
+	if entry.Identity != "" {
+		gelf["_identity"] = entry.Identity
+	}
+	for key, value := range entry.Fields {
+		name := "_" + fieldName(key, func(r rune) bool {
+			return r == '.' || r == '-' || r == '_' || r < 128 && (unicode.IsLetter(r) || unicode.IsDigit(r))
+		})
+		if name == "_id" {
+			name = "_id_" // Reserved
+		}
+		if _, taken := gelf[name]; !taken {
+			gelf[name] = value
+		}
+	}
+	bytes, err := json.Marshal(gelf)
+	if err != nil {
+		for key, value := range gelf {
+			gelf[key] = jsonValue(value)
+		}
+		bytes, _ = json.Marshal(gelf)
+	}
+	return bytes
+}
+
+// Splits a message that doesn't fit in one datagram into chunks, compressing it first. Returns nil
+// if it is too large to send even then
+func gelfChunks(payload []byte) [][]byte {
+	if len(payload) <= gelfChunkSize {
+		return [][]byte{payload}
+	}
+
+	var compressed bytes.Buffer
+	writer := zlib.NewWriter(&compressed)
+	writer.Write(payload)
+	writer.Close()
+	if payload = compressed.Bytes(); len(payload) <= gelfChunkSize {
+		return [][]byte{payload}
+	}
+
+	size := gelfChunkSize - gelfChunkHeader
+	count := (len(payload) + size - 1) / size
+	if count > gelfMaxChunks {
+		return nil
+	}
+
+	id := make([]byte, 8)
+	rand.Read(id)
+	chunks := make([][]byte, 0, count)
+	for seq := 0; seq < count; seq++ {
+		end := (seq + 1) * size
+		if end > len(payload) {
+			end = len(payload)
+		}
+		chunk := append(append(append([]byte{}, gelfMagic...), id...), byte(seq), byte(count))
+		chunks = append(chunks, append(chunk, payload[seq*size:end]...))
+	}
+	return chunks
+}
+
+type UdpGelfClient struct {
+	udpClient
+	gelfclient
+}
+
+// NewUdpGelfClient sends messages as GELF datagrams, chunked and compressed when they are too large for one
+func NewUdpGelfClient() GelfClient {
+	u := &UdpGelfClient{}
+	u.init(u)
+	u.initForwardClient()
+	u.framing = framingGelfChunked
+	return u
+}
+
+type TcpGelfClient struct {
+	tcpClient
+	gelfclient
+}
+
+// NewTcpGelfClient sends null byte delimited GELF messages. Reconnects and buffers like the other TCP clients
+func NewTcpGelfClient() GelfClient {
+	t := &TcpGelfClient{}
+	t.init(t)
+	t.initForwardClient()
+	t.framing = framingNull
+	return t
+}
@@ -0,0 +1,116 @@
+package socketlogger
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"crypto/rand"
+	"encoding/hex"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseGelf(t *testing.T) {
+	plain := []byte(`{"version":"1.1","host":"web-3","short_message":"disk almost full","full_message":"disk almost full\n/var at 97%","level":4,"timestamp":1631654091.25,"file":"df.py","line":12,"_disk":"/var"}`)
+
+	var gzipped, zipped bytes.Buffer
+	gz := gzip.NewWriter(&gzipped)
+	gz.Write(plain)
+	gz.Close()
+	z := zlib.NewWriter(&zipped)
+	z.Write(plain)
+	z.Close()
+
+	server := &gelfserver{}
+	for _, payload := range [][]byte{plain, gzipped.Bytes(), zipped.Bytes()} {
+		msg, err := server.decode(payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entry := msg.(*LogMessage)
+		if entry.LogLevel != MessageLevelWrn || entry.Caller != "web-3/df.py:12" || entry.Message != "disk almost full\ndisk almost full\n/var at 97%" {
+			t.Errorf("Unexpected message %+v", entry)
+		}
+		if entry.Fields["disk"] != "/var" {
+			t.Errorf("Additional fields should be kept: %v", entry.Fields)
+		}
+		if entry.Time == nil || !entry.Time.Equal(time.Unix(1631654091, 250000000)) {
+			t.Errorf("GELF timestamp should be kept: %v", entry.Time)
+		}
+	}
+
+	if msg, err := parseGelf([]byte(`{"host":"web-3","short_message":"no level"}`)); err != nil || msg.LogLevel != MessageLevelErr || msg.Caller != "web-3" {
+		t.Errorf("Missing level should be alert: %+v %v", msg, err)
+	}
+	for _, bad := range []string{`{"host":"web-3"}`, `{"short_message":"x","level":9}`, `not json`} {
+		if _, err := parseGelf([]byte(bad)); err == nil {
+			t.Errorf("%s should not parse", bad)
+		}
+	}
+}
+
+func TestGelfEncodeFields(t *testing.T) {
+	client := NewTcpGelfClient().(*TcpGelfClient)
+	encoded := client.encode(&LogMessage{Message: "m", Fields: Fields{"camera": 2, "id": "x", "a b": true}})
+	msg, err := parseGelf(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Fields["camera"] != 2.0 || msg.Fields["id_"] != "x" || msg.Fields["a_b"] != true {
+		t.Errorf("Fields should be sent as additional fields: %s", encoded)
+	}
+}
+
+func TestGelfChunks(t *testing.T) {
+	random := make([]byte, 10000)
+	rand.Read(random)
+	payload := []byte(`{"host":"web-3","short_message":"` + hex.EncodeToString(random) + `"}`)
+
+	chunks := gelfChunks(payload)
+	if len(chunks) < 2 {
+		t.Fatalf("Expected the message to be chunked, got %d chunks", len(chunks))
+	}
+	server := &gelfserver{}
+	for i := len(chunks) - 1; i >= 0; i-- { // Chunks may arrive in any order
+		msg, err := server.decode(chunks[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i > 0 && msg != nil {
+			t.Fatal("Message was returned before every chunk arrived")
+		} else if i == 0 && (msg == nil || msg.(*LogMessage).Message != hex.EncodeToString(random)) {
+			t.Fatalf("Reassembled message does not match: %v", msg)
+		}
+	}
+	if len(server.partials) != 0 {
+		t.Errorf("%d partial messages left behind", len(server.partials))
+	}
+
+	if small := gelfChunks([]byte(`{"short_message":"small"}`)); len(small) != 1 || string(small[0]) != `{"short_message":"small"}` {
+		t.Errorf("Small messages should be sent as is: %q", small)
+	}
+}
+
+func TestGelfForwarding(t *testing.T) {
+	dir := t.TempDir()
+	gelf := NewUdpGelfServer()
+	gelf.SetLogFile(dir, "gelf.log")
+	forwarder := NewUdpGelfClient()
+	forwarder.SetHostname("aggregator")
+	forwarder.Connect(Connection{Addr: "127.0.0.1"}, bindLocal(t, gelf))
+	server := NewTcpLoggerServer()
+	server.SetGelfForwarder(forwarder)
+
+	random := make([]byte, 10000)
+	rand.Read(random)
+	logger := connectLogger(t, bindLocal(t, server))
+	logger.Err("forwarded as GELF")
+	logger.Log("%s", hex.EncodeToString(random))
+	logger.Disconnect()
+	server.Shutdown()
+	forwarder.Disconnect()
+	gelf.Shutdown()
+
+	assertLogContains(t, filepath.Join(dir, "gelf.log"), "aggregator -- forwarded as GELF", "aggregator -- "+hex.EncodeToString(random))
+}
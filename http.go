@@ -0,0 +1,131 @@
+package socketlogger
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+)
+
+const (
+	maxRequestSize      int64         = 16 << 20
+	httpShutdownTimeout time.Duration = 5 * time.Second
+	httpHeaderTimeout   time.Duration = 10 * time.Second  // Clients that never finish their headers are dropped
+	httpIdleTimeout     time.Duration = 120 * time.Second // Keep-alive connections left unused are closed
+)
+
+// Accepts messages POSTed as JSON, either a single message or an array of them. Every message goes
+// through the same writer as the socket servers
+type httpserver struct {
+	server
+}
+
+// SetFraming is not used over HTTP, the request body is always JSON
+func (h *httpserver) SetFraming(framing Framing) error {
+	if framing != FramingStream {
+		return fmt.Errorf("framing is not supported by the HTTP server")
+	}
+	return nil
+}
+
+func (h *httpserver) buildSocket(c Connection) (net.Conn, error) {
+	listener, err := net.Listen(tcpProtocol, fmt.Sprintf("%v:%d", c.Addr, c.Port))
+	if err != nil {
+		return nil, err
+	}
+
+	protocol := "HTTP Server"
+	if h.tlsConfig != nil {
+		protocol = "HTTPS Server"
+		listener = tls.NewListener(listener, h.tlsConfig)
+	}
+	h.addr = connectionOf(listener.Addr())
+	h.submit(newLogMessage(MessageLevelSuccess, "%s listening at %s", protocol, listener.Addr()))
+
+	srv := &http.Server{
+		Handler:           http.HandlerFunc(h.handle),
+		ReadHeaderTimeout: httpHeaderTimeout,
+		IdleTimeout:       httpIdleTimeout,
+	}
+	h.onShutdown(func() {
+		// Lets requests that are being read finish before the writer is closed
+		ctx, cancel := context.WithTimeout(context.Background(), httpShutdownTimeout)
+		defer cancel()
+		srv.Shutdown(ctx)
+	})
+	go srv.Serve(listener)
+	return nil, nil
+}
+
+func (h *httpserver) handle(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "only POST is supported", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxRequestSize+1))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if int64(len(body)) > maxRequestSize {
+		http.Error(w, fmt.Sprintf("request body is larger than %d bytes", maxRequestSize), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	msgs, err := h.decodeBody(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	identity := ""
+	if r.TLS != nil {
+		identity = stateIdentity(*r.TLS)
+	}
+	for _, msg := range msgs {
+		h.vouch(msg, identity)
+		received(msg, r.RemoteAddr)
+		if !h.submit(msg) {
+			http.Error(w, "server is shutting down", http.StatusServiceUnavailable)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	fmt.Fprintf(w, "{\"accepted\":%d}\n", len(msgs))
+}
+
+// Nothing is written unless every message in the body is valid
+func (h *httpserver) decodeBody(body []byte) ([]SocketMessage, error) {
+	body = bytes.TrimSpace(body)
+	if len(body) == 0 {
+		return nil, fmt.Errorf("request body is empty")
+	}
+
+	raw := []json.RawMessage{body}
+	if body[0] == '[' {
+		if err := json.Unmarshal(body, &raw); err != nil {
+			return nil, fmt.Errorf("malformed batch: %v", err)
+		}
+	}
+
+	msgs := make([]SocketMessage, 0, len(raw))
+	for i, frame := range raw {
+		msg := h.this.(Server).getMessageType()
+		if err := json.Unmarshal(frame, msg); err != nil {
+			return nil, fmt.Errorf("malformed message %d: %v", i, err)
+		}
+		if csv, ok := msg.(*CsvMessage); ok && csv.Filename == "" {
+			return nil, fmt.Errorf("message %d has no csv_filename", i)
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs, nil
+}
@@ -0,0 +1,69 @@
+package socketlogger
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestHttpCsvServer(t *testing.T) {
+	dir := t.TempDir()
+	server := NewHttpCsvServer()
+	server.SetOutputCsvDirectory(dir)
+	url := fmt.Sprintf("http://127.0.0.1:%d/", bindLocal(t, server).Port)
+	requests := []struct {
+		method string
+		body   string
+		status int
+	}{
+		{http.MethodPost, `{"caller":"http_test.go","row":[1,"one"],"csv_filename":"http.csv"}`, http.StatusAccepted},
+		{http.MethodPost, `[{"row":[2,"two"],"csv_filename":"http.csv"},{"row":[3,"three"],"csv_filename":"http.csv"}]`, http.StatusAccepted},
+		{http.MethodPost, `{"row":[4,"four"],"csv_filename":`, http.StatusBadRequest},
+		{http.MethodPost, `[{"row":[5,"five"],"csv_filename":"http.csv"},{"row":[6,"six"]}]`, http.StatusBadRequest}, // All or nothing
+		{http.MethodPost, `[{"row":"seven","csv_filename":"http.csv"}]`, http.StatusBadRequest},
+		{http.MethodPost, ``, http.StatusBadRequest},
+		{http.MethodGet, ``, http.StatusMethodNotAllowed},
+	}
+	for i, r := range requests {
+		req, _ := http.NewRequest(r.method, url, strings.NewReader(r.body))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != r.status {
+			t.Errorf("Request %d: expected status %d, actual %d", i, r.status, resp.StatusCode)
+		}
+	}
+	server.Shutdown()
+
+	dat, _ := os.ReadFile(filepath.Join(dir, "http.csv"))
+	if expected := "1,one\n2,two\n3,three\n"; string(dat) != expected {
+		t.Errorf("Expected:\n%s\nActual:\n%s", expected, dat)
+	}
+}
+
+func TestHttpLoggerServer(t *testing.T) {
+	dir := t.TempDir()
+	server := NewHttpLoggerServer()
+	server.SetLogFile(dir, "http.log")
+	remote := bindLocal(t, server)
+
+	resp, err := http.Post(fmt.Sprintf("http://127.0.0.1:%d/", remote.Port), "application/json", strings.NewReader(`{"caller":"deploy.sh","level":2,"message":"deployed from curl","identity":"spoofed"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		t.Errorf("Expected status %d, actual %d", http.StatusAccepted, resp.StatusCode)
+	}
+	server.Shutdown()
+
+	dat := assertLogContains(t, filepath.Join(dir, "http.log"), "deploy.sh -- deployed from curl")
+	if strings.Contains(dat, "spoofed") {
+		t.Errorf("Identity sent by the client was trusted:\n%s", dat)
+	}
+}
@@ -1,45 +1,155 @@
 package socketlogger
 
 import (
+	"context"
+	"fmt"
 	"io"
-	"log"
-	"os"
 	"path/filepath"
+	"regexp"
 	"runtime"
+	"strings"
+	"sync"
+	"time"
 )
 
 type LoggerServer interface {
 	SetLogFile(string, string) error
+	SetOutput(w io.Writer)
 	SetTimeFlags(flags int) error
+	SetTimestamps(timestamps Timestamps) error
+	SetClockSkewWarning(threshold time.Duration) error
+	AddSink(sink Sink, levels ...messageLevel) error
+	SetLiveTail(tail *LiveTail)
+	SetSyslogForwarder(client SyslogClient)
+	SetGelfForwarder(client GelfClient)
+	SetUpstream(client LoggerClient)
+	Reopen() error
 	Server
 }
 
 type loggerserver struct {
-	flush chan bool
+	text      *TextSink // Output and time flags of this server only, see textSink()
+	textEntry sinkEntry // Reports errors writing text like those of the sinks
+	flush     chan bool
+	sinks     []*sinkEntry // Get every message after the text output, in the order they were added
+	mu        sync.Mutex   // Held while a message is written, so Reopen doesn't swap files under it
 }
 
+// Somewhere besides the log file that messages are sent to
+type output interface {
+	publish(msg *LogMessage)
+}
+
+// SetLogFile writes every message to the console and to the file name in dir, which is created if needed
 func (l *loggerserver) SetLogFile(dir, name string) error {
-	var err error
-	var logFile *os.File
-	if !fileDirExists(dir, "") {
-		err = os.MkdirAll(dir, os.ModePerm)
-		if err != nil {
-			return err
-		}
-	}
-	logFile, err = os.OpenFile(filepath.Join(dir, name), os.O_CREATE|os.O_APPEND|os.O_RDWR, 0o666)
+	logFile, err := openLogFile(filepath.Join(dir, name))
 	if err == nil {
-		log.SetOutput(io.MultiWriter(os.Stdout, logFile))
+		l.mu.Lock()
+		l.textSink().useFile(logFile, console{})
+		l.mu.Unlock()
 	}
 
 	return err
 }
 
+// SetOutput writes every message to w instead of the console and log file. Each server has its own
+// output, the log package's is left alone. Use io.Discard to only write to the sinks
+func (l *loggerserver) SetOutput(w io.Writer) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.textSink().SetOutput(w)
+}
+
+// SetTimeFlags sets the log package flags used for the time in front of every line, log.LstdFlags by default
 func (l *loggerserver) SetTimeFlags(flags int) error {
-	log.SetFlags(flags)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.textSink().SetTimeFlags(flags)
 	return nil
 }
 
+// The console until SetLogFile or SetOutput is called. Called with l.mu held, the writer may be using it
+func (l *loggerserver) textSink() *TextSink {
+	if l.text == nil {
+		l.text = NewConsoleSink()
+		l.textEntry.sink = l.text
+	}
+	return l.text
+}
+
+// SetTimestamps picks whether lines start with the time the server wrote them, the time the client
+// created them, or both. Client times are only known for messages from clients that send them
+func (l *loggerserver) SetTimestamps(timestamps Timestamps) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.textSink().SetTimestamps(timestamps)
+}
+
+// SetClockSkewWarning flags lines whose client time is more than threshold away from the server time,
+// 5 seconds by default. 0 turns the warning off
+func (l *loggerserver) SetClockSkewWarning(threshold time.Duration) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.textSink().SetClockSkewWarning(threshold)
+}
+
+// AddSink writes every message at one of levels, or every message if none are given, to sink as
+// well. Shutdown closes the sink. Must be called before Bind
+func (l *loggerserver) AddSink(sink Sink, levels ...messageLevel) error {
+	entry := &sinkEntry{sink: sink}
+	for _, lvl := range levels {
+		if _, ok := levelNames[lvl]; !ok {
+			return fmt.Errorf("unknown level %d", lvl)
+		}
+		if entry.levels == nil {
+			entry.levels = make(map[messageLevel]bool, len(levels))
+		}
+		entry.levels[lvl] = true
+	}
+	l.sinks = append(l.sinks, entry)
+	return nil
+}
+
+// SetLiveTail streams every message this server writes to the viewers of tail. Several servers
+// can share one tail. Must be called before Bind
+func (l *loggerserver) SetLiveTail(tail *LiveTail) {
+	l.AddSink(publishTo(tail))
+}
+
+// SetSyslogForwarder sends every message this server writes on to a syslog collector. The client
+// must be connected before Bind and disconnected after Shutdown
+func (l *loggerserver) SetSyslogForwarder(client SyslogClient) {
+	l.AddSink(publishTo(client))
+}
+
+// SetGelfForwarder sends every message this server writes on to Graylog, or anything else that
+// accepts GELF. The client must be connected before Bind and disconnected after Shutdown
+func (l *loggerserver) SetGelfForwarder(client GelfClient) {
+	l.AddSink(publishTo(client))
+}
+
+// SetUpstream re-sends every message this server writes to another logger server, keeping the caller
+// and adding the name of this machine. The client must be connected before Bind and disconnected after Shutdown
+func (l *loggerserver) SetUpstream(client LoggerClient) {
+	l.AddSink(publishTo(newUpstream(client)))
+}
+
+// Reopen opens the log file and the files of the sinks again by their paths, for logrotate. Messages
+// that arrive meanwhile wait and are written to the new files
+func (l *loggerserver) Reopen() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	err := l.textSink().Reopen()
+	for _, entry := range l.sinks {
+		if r, ok := entry.sink.(reopener); ok {
+			if reopenErr := r.Reopen(); err == nil {
+				err = reopenErr
+			}
+		}
+	}
+	return err
+}
+
 func (l *loggerserver) getMessageType() SocketMessage {
 	return &LogMessage{}
 }
@@ -50,11 +160,33 @@ func (l *loggerserver) setFlushChannel(flush chan bool) {
 
 func (l *loggerserver) write(msgs chan SocketMessage) {
 	for msg := range msgs {
-		log.Printf("%s\n", msg)
+		l.mu.Lock()
+		l.checkSink(&l.textEntry, l.textSink().Write(msg))
+		for _, entry := range l.sinks {
+			if entry.accepts(msg) {
+				l.checkSink(entry, entry.sink.Write(msg))
+			}
+		}
+		l.mu.Unlock()
+	}
+	l.mu.Lock()
+	for _, entry := range l.sinks {
+		l.checkSink(entry, entry.sink.Close())
 	}
+	l.textSink().Close()
+	l.mu.Unlock()
 	l.flush <- true
 }
 
+// Writes an error from a sink to the text output, once until the sink fails differently
+func (l *loggerserver) checkSink(entry *sinkEntry, err error) {
+	if err == nil || err.Error() == entry.lastErr {
+		return
+	}
+	entry.lastErr = err.Error()
+	l.text.Write(newLogMessage(MessageLevelErr, "Sink %T failed: %v", entry.sink, err))
+}
+
 type UdpLoggerServer struct {
 	loggerserver
 	udpserver
@@ -77,54 +209,392 @@ func NewTcpLoggerServer() LoggerServer {
 	return t
 }
 
+type UnixLoggerServer struct {
+	unixserver
+	loggerserver
+}
+
+// NewUnixLoggerServer listens for stream connections on Connection.Path
+func NewUnixLoggerServer() LoggerServer {
+	u := &UnixLoggerServer{}
+	u.init(u)
+	return u
+}
+
+type UnixgramLoggerServer struct {
+	unixgramserver
+	loggerserver
+}
+
+// NewUnixgramLoggerServer listens for datagrams on Connection.Path
+func NewUnixgramLoggerServer() LoggerServer {
+	u := &UnixgramLoggerServer{}
+	u.init(u)
+	return u
+}
+
+type HttpLoggerServer struct {
+	httpserver
+	loggerserver
+}
+
+// NewHttpLoggerServer accepts LogMessages POSTed as JSON, one per request or an array of them
+func NewHttpLoggerServer() LoggerServer {
+	h := &HttpLoggerServer{}
+	h.init(h)
+	return h
+}
+
+type UdpSyslogServer struct {
+	udpserver
+	loggerserver
+	syslogserver
+}
+
+// NewUdpSyslogServer accepts syslog messages, one per datagram (RFC 5426)
+func NewUdpSyslogServer() LoggerServer {
+	u := &UdpSyslogServer{}
+	u.init(u)
+	u.framing = framingDatagram
+	return u
+}
+
+type TcpSyslogServer struct {
+	tcpserver
+	loggerserver
+	syslogserver
+}
+
+// NewTcpSyslogServer accepts syslog messages framed by octet counting or newlines (RFC 6587),
+// and over TLS (RFC 5425) once SetTLSConfig is called
+func NewTcpSyslogServer() LoggerServer {
+	t := &TcpSyslogServer{}
+	t.init(t)
+	t.framing = framingSyslog
+	return t
+}
+
+type UdpGelfServer struct {
+	udpserver
+	loggerserver
+	gelfserver
+}
+
+// NewUdpGelfServer accepts GELF datagrams, including chunked and zlib or gzip compressed ones
+func NewUdpGelfServer() LoggerServer {
+	u := &UdpGelfServer{}
+	u.init(u)
+	u.framing = framingDatagram
+	return u
+}
+
+type TcpGelfServer struct {
+	tcpserver
+	loggerserver
+	gelfserver
+}
+
+// NewTcpGelfServer accepts null byte delimited GELF messages
+func NewTcpGelfServer() LoggerServer {
+	t := &TcpGelfServer{}
+	t.init(t)
+	t.framing = framingNull
+	return t
+}
+
 type LoggerClient interface {
 	Log(format string, args ...interface{})
 	Wrn(format string, args ...interface{})
 	Dbg(format string, args ...interface{})
 	Err(format string, args ...interface{})
 	Success(format string, args ...interface{})
+	// Same as the methods above with structured fields, e.g. Logw("frame dropped", "camera", 2, "queue", 31)
+	Logw(msg string, keysAndValues ...interface{})
+	Wrnw(msg string, keysAndValues ...interface{})
+	Dbgw(msg string, keysAndValues ...interface{})
+	Errw(msg string, keysAndValues ...interface{})
+	Successw(msg string, keysAndValues ...interface{})
 	Write(p []byte) (n int, err error) // io.Writer interface
+	AddLevelRule(pattern string, level messageLevel) error
+	AddLevelPrefix(prefix string, level messageLevel) error
+	With(keysAndValues ...interface{}) LoggerClient
+	Named(name string) LoggerClient
+	// Same as Log, Wrn, ... with the fields the context extractor finds in ctx, e.g. the trace ID
+	LogCtx(ctx context.Context, format string, args ...interface{})
+	WrnCtx(ctx context.Context, format string, args ...interface{})
+	DbgCtx(ctx context.Context, format string, args ...interface{})
+	ErrCtx(ctx context.Context, format string, args ...interface{})
+	SuccessCtx(ctx context.Context, format string, args ...interface{})
+	WithContext(ctx context.Context) LoggerClient
+	SetContextExtractor(extractor func(ctx context.Context) Fields)
 	Client
+
+	contextFields(ctx context.Context) Fields
 }
 
 type loggerclient struct {
 	msgsToSend chan SocketMessage
+	levelRules []levelRule // Checked in order by Write, the first match sets the level
+	fields     Fields      // Added to every message, from With and Named
+	extractor  func(ctx context.Context) Fields
+	owner      Client // The client the loggerclient is part of, which owns the connection
+	child      bool   // Sends through owner, as msgsToSend is only set on the owner
 }
 
+const loggerField string = "logger" // Field Named sets
+
+type levelRule struct {
+	pattern *regexp.Regexp
+	level   messageLevel
+	prefix  string // From AddLevelPrefix, which the log package writes before the caller
+}
+
+// The file:line: written by log.Lshortfile or log.Llongfile, right after the date and time the log
+// package writes. Anything else that looks like one is left in the message
+var writerCaller = regexp.MustCompile(`(?s)^((?:\d{4}/\d\d/\d\d )?(?:\d\d:\d\d:\d\d(?:\.\d+)? )?)(\S+\.go:\d+): (.*)$`)
+
 func (l *loggerclient) setMsgChannel(msgsToSend chan SocketMessage) {
 	l.msgsToSend = msgsToSend
 }
 
 func (l *loggerclient) Log(format string, args ...interface{}) {
 	_, file, line, ok := runtime.Caller(1)
-	l.msgsToSend <- newLogMessageCaller(MessageLevelLog, file, line, ok, format, args...)
+	l.send(newLogMessageCaller(MessageLevelLog, file, line, ok, format, args...).(*LogMessage))
 }
 
 func (l *loggerclient) Wrn(format string, args ...interface{}) {
 	_, file, line, ok := runtime.Caller(1)
-	l.msgsToSend <- newLogMessageCaller(MessageLevelWrn, file, line, ok, format, args...)
+	l.send(newLogMessageCaller(MessageLevelWrn, file, line, ok, format, args...).(*LogMessage))
 }
 
 func (l *loggerclient) Dbg(format string, args ...interface{}) {
 	_, file, line, ok := runtime.Caller(1)
-	l.msgsToSend <- newLogMessageCaller(MessageLevelDbg, file, line, ok, format, args...)
+	l.send(newLogMessageCaller(MessageLevelDbg, file, line, ok, format, args...).(*LogMessage))
 }
 
 func (l *loggerclient) Err(format string, args ...interface{}) {
 	_, file, line, ok := runtime.Caller(1)
-	l.msgsToSend <- newLogMessageCaller(MessageLevelErr, file, line, ok, format, args...)
+	l.send(newLogMessageCaller(MessageLevelErr, file, line, ok, format, args...).(*LogMessage))
 }
 
 func (l *loggerclient) Success(format string, args ...interface{}) {
 	_, file, line, ok := runtime.Caller(1)
-	l.msgsToSend <- newLogMessageCaller(MessageLevelSuccess, file, line, ok, format, args...)
+	l.send(newLogMessageCaller(MessageLevelSuccess, file, line, ok, format, args...).(*LogMessage))
+}
+
+func (l *loggerclient) Logw(msg string, keysAndValues ...interface{}) {
+	l.sendw(MessageLevelLog, msg, keysAndValues)
+}
+
+func (l *loggerclient) Wrnw(msg string, keysAndValues ...interface{}) {
+	l.sendw(MessageLevelWrn, msg, keysAndValues)
+}
+
+func (l *loggerclient) Dbgw(msg string, keysAndValues ...interface{}) {
+	l.sendw(MessageLevelDbg, msg, keysAndValues)
+}
+
+func (l *loggerclient) Errw(msg string, keysAndValues ...interface{}) {
+	l.sendw(MessageLevelErr, msg, keysAndValues)
+}
+
+func (l *loggerclient) Successw(msg string, keysAndValues ...interface{}) {
+	l.sendw(MessageLevelSuccess, msg, keysAndValues)
 }
 
+// Called by the methods above, so the caller is two frames up
+func (l *loggerclient) sendw(lvl messageLevel, msg string, keysAndValues []interface{}) {
+	_, file, line, ok := runtime.Caller(2)
+	entry := newLogMessageCaller(lvl, file, line, ok, "%s", msg).(*LogMessage)
+	entry.Fields = fieldsOf(keysAndValues)
+	l.send(entry)
+}
+
+func (l *loggerclient) LogCtx(ctx context.Context, format string, args ...interface{}) {
+	l.sendCtx(ctx, MessageLevelLog, format, args)
+}
+
+func (l *loggerclient) WrnCtx(ctx context.Context, format string, args ...interface{}) {
+	l.sendCtx(ctx, MessageLevelWrn, format, args)
+}
+
+func (l *loggerclient) DbgCtx(ctx context.Context, format string, args ...interface{}) {
+	l.sendCtx(ctx, MessageLevelDbg, format, args)
+}
+
+func (l *loggerclient) ErrCtx(ctx context.Context, format string, args ...interface{}) {
+	l.sendCtx(ctx, MessageLevelErr, format, args)
+}
+
+func (l *loggerclient) SuccessCtx(ctx context.Context, format string, args ...interface{}) {
+	l.sendCtx(ctx, MessageLevelSuccess, format, args)
+}
+
+// Called by the methods above, so the caller is two frames up
+func (l *loggerclient) sendCtx(ctx context.Context, lvl messageLevel, format string, args []interface{}) {
+	_, file, line, ok := runtime.Caller(2)
+	entry := newLogMessageCaller(lvl, file, line, ok, format, args...).(*LogMessage)
+	entry.Fields = l.contextFields(ctx)
+	l.send(entry)
+}
+
+// SetContextExtractor replaces ContextFields, which only knows the IDs stored by ContextWithTrace and
+// ContextWithRequestID, e.g. to take the IDs from an OpenTelemetry span. Child loggers keep the extractor
+// their parent had when they were created
+func (l *loggerclient) SetContextExtractor(extractor func(ctx context.Context) Fields) {
+	l.extractor = extractor
+}
+
+func (l *loggerclient) contextFields(ctx context.Context) Fields {
+	if ctx == nil {
+		return nil
+	} else if l.extractor != nil {
+		return l.extractor(ctx)
+	}
+	return ContextFields(ctx)
+}
+
+// AddLevelRule sends lines written to Write that match pattern at level instead of Log. Rules are
+// checked in the order they were added. Must be called before Write is used
+func (l *loggerclient) AddLevelRule(pattern string, level messageLevel) error {
+	if _, ok := levelNames[level]; !ok {
+		return fmt.Errorf("unknown level %d", level)
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return err
+	}
+	l.levelRules = append(l.levelRules, levelRule{pattern: re, level: level})
+	return nil
+}
+
+// AddLevelPrefix is AddLevelRule for lines starting with prefix, e.g. "ERROR: " or a log.New prefix.
+// The file:line after a log.New prefix is only found once the prefix is added here
+func (l *loggerclient) AddLevelPrefix(prefix string, level messageLevel) error {
+	if err := l.AddLevelRule("^"+regexp.QuoteMeta(prefix), level); err != nil {
+		return err
+	}
+	l.levelRules[len(l.levelRules)-1].prefix = prefix
+	return nil
+}
+
+// Write sends each line from the log package. The file:line from NativeFlags becomes the caller, and
+// the level rules are matched against the rest of the line
 func (l *loggerclient) Write(p []byte) (int, error) {
-	l.msgsToSend <- newLogMessageCaller(MessageLevelLog, "embedded", 0, false, string(p))
+	msg := newLogMessageCaller(MessageLevelLog, "embedded", 0, false, "%s", p).(*LogMessage)
+	prefix := ""
+	for _, rule := range l.levelRules {
+		if rule.prefix != "" && strings.HasPrefix(msg.Message, rule.prefix) {
+			prefix = rule.prefix
+			break
+		}
+	}
+	if match := writerCaller.FindStringSubmatch(msg.Message[len(prefix):]); match != nil {
+		msg.Caller = filepath.Base(match[2])
+		msg.Message = prefix + match[1] + match[3]
+	}
+	for _, rule := range l.levelRules {
+		if rule.pattern.MatchString(msg.Message) {
+			msg.LogLevel = rule.level
+			break
+		}
+	}
+	l.send(msg)
 	return len(p), nil
 }
 
+// With returns a logger that adds keysAndValues to the fields of every message. Child loggers share the
+// connection of the client they came from, so goroutines can each have their own
+func (l *loggerclient) With(keysAndValues ...interface{}) LoggerClient {
+	child := l.newChild()
+	for key, value := range fieldsOf(keysAndValues) {
+		child.fields[key] = value
+	}
+	return child
+}
+
+// Named returns a logger that sends name in the logger field of every message. Names of nested
+// loggers are joined with dots, e.g. camera.left
+func (l *loggerclient) Named(name string) LoggerClient {
+	child := l.newChild()
+	if parent, ok := l.fields[loggerField].(string); ok && parent != "" {
+		name = parent + "." + name
+	}
+	child.fields[loggerField] = name
+	return child
+}
+
+// WithContext returns a logger that adds the fields the context extractor finds in ctx to every message
+func (l *loggerclient) WithContext(ctx context.Context) LoggerClient {
+	child := l.newChild()
+	for key, value := range l.contextFields(ctx) {
+		child.fields[key] = value
+	}
+	return child
+}
+
+func (l *loggerclient) newChild() *childLogger {
+	child := &childLogger{
+		loggerclient: loggerclient{
+			levelRules: append([]levelRule(nil), l.levelRules...),
+			fields:     make(Fields, len(l.fields)+1),
+			extractor:  l.extractor,
+			owner:      l.owner,
+			child:      true,
+		},
+		Client: l.owner,
+	}
+	for key, value := range l.fields {
+		child.fields[key] = value
+	}
+	return child
+}
+
+// Adds the fields from With and Named, the message's own fields win
+func (l *loggerclient) send(msg *LogMessage) {
+	if len(l.fields) > 0 {
+		fields := make(Fields, len(l.fields)+len(msg.Fields))
+		for key, value := range l.fields {
+			fields[key] = value
+		}
+		for key, value := range msg.Fields {
+			fields[key] = value
+		}
+		msg.Fields = fields
+	}
+
+	if l.child {
+		l.owner.Send(msg)
+	} else {
+		l.msgsToSend <- msg
+	}
+}
+
+// Returned by With and Named. Everything but logging goes to the client that owns the connection,
+// apart from Connect and Disconnect, which are left to that client
+type childLogger struct {
+	loggerclient
+	Client
+}
+
+func (c *childLogger) Connect(client, server Connection) error {
+	return fmt.Errorf("child loggers use the connection of the client they came from")
+}
+
+func (c *childLogger) Disconnect() {}
+
+// Send adds the child's fields to log messages, so handlers and adapters that send straight to the
+// client keep them
+func (c *childLogger) Send(msg SocketMessage) {
+	if logMsg, ok := msg.(*LogMessage); ok {
+		c.send(logMsg)
+		return
+	}
+	c.Client.Send(msg)
+}
+
+func (c *childLogger) setMsgChannel(msgsToSend chan SocketMessage) {}
+
 type UdpLoggerClient struct {
 	loggerclient
 	udpClient
@@ -133,6 +603,7 @@ type UdpLoggerClient struct {
 func NewUdpLoggerClient() LoggerClient {
 	u := &UdpLoggerClient{}
 	u.init(u)
+	u.owner = u
 	return u
 }
 
@@ -144,5 +615,30 @@ type TcpLoggerClient struct {
 func NewTcpLoggerClient() LoggerClient {
 	t := &TcpLoggerClient{}
 	t.init(t)
+	t.owner = t
 	return t
 }
+
+type UnixLoggerClient struct {
+	loggerclient
+	unixClient
+}
+
+func NewUnixLoggerClient() LoggerClient {
+	u := &UnixLoggerClient{}
+	u.init(u)
+	u.owner = u
+	return u
+}
+
+type UnixgramLoggerClient struct {
+	loggerclient
+	unixgramClient
+}
+
+func NewUnixgramLoggerClient() LoggerClient {
+	u := &UnixgramLoggerClient{}
+	u.init(u)
+	u.owner = u
+	return u
+}
@@ -6,9 +6,11 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"regexp"
 	"runtime"
 	"strconv"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -604,3 +606,116 @@ func checkMsgType(server LoggerServer) bool {
 		return false
 	}
 }
+
+func TestWriterLevels(t *testing.T) {
+	server, path, remote := startLoggerServer(t)
+	client := NewTcpLoggerClient()
+	client.AddLevelPrefix("ERROR: ", MessageLevelErr)
+	client.AddLevelRule(`(?i)\bwarn(ing)?\b`, MessageLevelWrn)
+	if err := client.AddLevelRule(`(`, MessageLevelErr); err == nil {
+		t.Error("Bad pattern was accepted")
+	}
+	client.Connect(Connection{}, remote)
+	native := log.New(client, "", NativeFlags)
+	_, _, line, _ := runtime.Caller(0)
+	native.Println("disk at 100%")
+	native.Println("Warning: disk almost full")
+	log.New(client, "ERROR: ", NativeFlags).Println("disk full")
+	log.New(client, "", log.LstdFlags|log.Lshortfile).Println("dated")
+	native.Println("parse error at config.go:12: bad key")
+	fmt.Fprintln(client, "no caller")
+	client.Disconnect()
+	server.Shutdown()
+
+	dat := assertLogContains(t, path,
+		string(reset)+fmt.Sprintf(" | logger_test.go:%d -- disk at 100%%", line+1),
+		string(yellow)+fmt.Sprintf(" | logger_test.go:%d -- Warning: disk almost full", line+2),
+		string(red)+fmt.Sprintf(" | logger_test.go:%d -- ERROR: disk full", line+3),
+		fmt.Sprintf(" | logger_test.go:%d -- %s ", line+4, time.Now().Format("2006/01/02")), // Then the time and "dated"
+		fmt.Sprintf(" | logger_test.go:%d -- parse error at config.go:12: bad key", line+5),
+		string(reset)+" | no caller",
+	)
+	if strings.Contains(dat, "| config.go:12") {
+		t.Errorf("A file:line inside the message was taken as the caller:\n%s", dat)
+	}
+}
+
+func TestChildLoggers(t *testing.T) {
+	server, path, remote := startLoggerServer(t)
+	client := NewTcpLoggerClient()
+	rig := client.With("rig", 7) // Before Connect, children only use the connection when they send
+	client.Connect(Connection{}, remote)
+	if err := rig.Connect(Connection{}, Connection{}); err == nil {
+		t.Error("Child loggers should not connect")
+	}
+
+	var wg sync.WaitGroup
+	for _, camera := range []string{"left", "right"} {
+		wg.Add(1)
+		go func(camera string) {
+			defer wg.Done()
+			logger := rig.Named("camera").Named(camera)
+			for i := 0; i < 100; i++ {
+				logger.Logw("frame", "n", i)
+			}
+			logger.Disconnect() // Leaves the shared connection alone
+		}(camera)
+	}
+	wg.Wait()
+	rig.With("rig", 8).Wrn("overridden")
+	client.Dbg("no context")
+	client.Disconnect()
+	server.Shutdown()
+
+	dat := assertLogContains(t, path, "-- frame logger=camera.left n=99 rig=7", "-- frame logger=camera.right n=99 rig=7", "-- overridden rig=8")
+	if lines := strings.Count(dat, "-- frame "); lines != 200 {
+		t.Errorf("Expected 200 frames from the children, got %d", lines)
+	}
+	if !strings.Contains(dat, "-- no context"+string(reset)) {
+		t.Errorf("The parent should not get the fields of its children:\n%s", dat)
+	}
+}
+
+func TestIndependentServers(t *testing.T) {
+	dir := t.TempDir()
+	var host strings.Builder
+	log.SetOutput(&host)
+	defer log.SetOutput(os.Stderr)
+
+	plain := NewTcpLoggerServer()
+	plain.SetLogFile(dir, "plain.log")
+	plain.SetTimeFlags(0)
+	plainAddr := bindLocal(t, plain)
+	var embedded strings.Builder
+	micro := NewTcpLoggerServer()
+	micro.SetOutput(&embedded)
+	micro.SetTimeFlags(log.Lmicroseconds)
+	microAddr := bindLocal(t, micro)
+
+	for remote, text := range map[Connection]string{plainAddr: "to plain", microAddr: "to micro"} {
+		client := connectLogger(t, remote)
+		client.Log(text)
+		client.Disconnect()
+	}
+	plain.Shutdown()
+	micro.Shutdown()
+
+	dat, _ := os.ReadFile(filepath.Join(dir, "plain.log"))
+	if !strings.Contains(string(dat), "to plain") || strings.Contains(string(dat), "to micro") {
+		t.Errorf("Expected only the plain server's messages:\n%s", dat)
+	}
+	for _, line := range strings.Split(strings.TrimSpace(string(dat)), "\n") {
+		if !strings.HasPrefix(line, string(reset)) {
+			t.Errorf("Time flags 0 should leave the time out: %q", line)
+		}
+	}
+	if !strings.Contains(embedded.String(), "to micro") || strings.Contains(embedded.String(), "to plain") {
+		t.Errorf("Expected only the micro server's messages:\n%s", embedded.String())
+	}
+	if !regexp.MustCompile(`(?m)^\d\d:\d\d:\d\d\.\d{6} `).MatchString(embedded.String()) {
+		t.Errorf("Expected microsecond times:\n%s", embedded.String())
+	}
+	if host.Len() != 0 {
+		t.Errorf("The log package output should be left alone:\n%s", host.String())
+	}
+}
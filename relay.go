@@ -0,0 +1,57 @@
+package socketlogger
+
+import (
+	"os"
+	"strings"
+)
+
+// Re-sends the messages a server writes to an upstream socketlogger server. Any client type works,
+// the TCP clients reconnect and buffer (or spool) while the upstream server is down
+type upstream struct {
+	client Client
+	host   string // Name of this machine, added to messages that don't have one yet
+}
+
+func newUpstream(client Client) *upstream {
+	host, _ := os.Hostname()
+	return &upstream{client: client, host: host}
+}
+
+func (u *upstream) publish(msg *LogMessage) {
+	u.send(msg)
+}
+
+// The envelope belongs to the hop the message arrived on, only the originating host and time, and whether
+// it was held back on the way, are kept
+func (u *upstream) send(msg SocketMessage) {
+	relayed := copyMessage(msg)
+	if e, ok := relayed.(enveloped); ok {
+		host := e.env().Host
+		if host == "" {
+			host = u.host
+		}
+		*e.env() = envelope{Host: host, Time: e.env().Time, Buffered: e.env().Buffered}
+	}
+	u.client.Send(relayed)
+}
+
+func copyMessage(msg SocketMessage) SocketMessage {
+	switch inst := msg.(type) {
+	case *LogMessage:
+		cp := *inst
+		return &cp
+	case *CsvMessage:
+		cp := *inst
+		return &cp
+	}
+	return msg
+}
+
+// Relayed csv files are kept apart per host, so a host name must not be able to leave the output directory
+func hostDir(host string) string {
+	host = strings.NewReplacer("/", "_", "\\", "_").Replace(host)
+	if host == "." || host == ".." {
+		return "_"
+	}
+	return host
+}
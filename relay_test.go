@@ -0,0 +1,104 @@
+package socketlogger
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRelaySurvivesUpstreamOutage(t *testing.T) {
+	host, _ := os.Hostname()
+	logDir, rigCsv, centralCsv := t.TempDir(), t.TempDir(), t.TempDir()
+
+	// The rig comes up first, the central servers are down
+	logCentral := Connection{Addr: "127.0.0.1", Port: freePort(t)}
+	csvCentral := Connection{Addr: "127.0.0.1", Port: freePort(t)}
+	logUpstream := NewTcpLoggerClient()
+	logUpstream.Connect(Connection{}, logCentral)
+	csvUpstream := NewTcpCsvClient()
+	csvUpstream.Connect(Connection{}, csvCentral)
+
+	rigLogs := NewTcpLoggerServer()
+	rigLogs.SetLogFile(logDir, "relay.log")
+	rigLogs.SetUpstream(logUpstream)
+	rigRows := NewTcpCsvServer()
+	rigRows.SetOutputCsvDirectory(rigCsv)
+	rigRows.SetUpstream(csvUpstream)
+
+	logger := connectLogger(t, bindLocal(t, rigLogs))
+	rows := NewTcpCsvClient()
+	rows.Connect(Connection{}, bindLocal(t, rigRows))
+	logger.Wrn("sent while central was down")
+	rows.AppendRow("temps.csv", []interface{}{1, 20.5})
+	waitForLog(t, filepath.Join(logDir, "relay.log"), "sent while central was down")
+	waitForLog(t, filepath.Join(rigCsv, "temps.csv"), "1,20.5")
+
+	centralLogs := NewTcpLoggerServer()
+	centralLogs.SetLogFile(logDir, "central.log")
+	centralLogs.SetAcceptRelayed(true)
+	centralLogs.Bind(logCentral)
+	centralRows := NewTcpCsvServer()
+	centralRows.SetOutputCsvDirectory(centralCsv)
+	centralRows.SetAcceptRelayed(true)
+	centralRows.Bind(csvCentral)
+	for _, client := range []Client{logUpstream, csvUpstream} {
+		waitFor(t, "the upstream client to reconnect", func() bool {
+			return client.Status() == StatusConnected
+		})
+	}
+
+	logger.Log("sent after central came up")
+	rows.AppendRow("temps.csv", []interface{}{2, 21.0})
+	logger.Disconnect()
+	rows.Disconnect()
+	rigLogs.Shutdown()
+	rigRows.Shutdown()
+	logUpstream.Disconnect()
+	csvUpstream.Disconnect()
+	centralLogs.Shutdown()
+	centralRows.Shutdown()
+
+	dat, _ := os.ReadFile(filepath.Join(logDir, "central.log"))
+	for _, expected := range []string{"sent while central was down", "sent after central came up"} {
+		if relayed := " | " + host + " | relay_test.go:"; !strings.Contains(string(dat), relayed) || !strings.Contains(string(dat), expected) {
+			t.Errorf("%q was not relayed with %q:\n%s", expected, relayed, dat)
+		}
+	}
+
+	dat, _ = os.ReadFile(filepath.Join(centralCsv, hostDir(host), "temps.csv"))
+	if expected := "1,20.5\n2,21\n"; string(dat) != expected {
+		t.Errorf("Expected relayed rows:\n%s\nActual:\n%s", expected, dat)
+	}
+	if dat, _ = os.ReadFile(filepath.Join(rigCsv, "temps.csv")); string(dat) != "1,20.5\n2,21\n" {
+		t.Errorf("Rig should keep its own copy of the rows:\n%s", dat)
+	}
+}
+
+func TestClientCannotPickHost(t *testing.T) {
+	dir := t.TempDir()
+	server := NewTcpCsvServer()
+	server.SetOutputCsvDirectory(dir)
+	client := NewTcpCsvClient()
+	client.Connect(Connection{}, bindLocal(t, server))
+	sent := time.Now()
+	client.Send(&CsvMessage{Filename: "rows.csv", Row: []interface{}{1}, envelope: envelope{Host: "other-rig", Time: &sent}})
+	client.Disconnect()
+	server.Shutdown()
+
+	if fileExists(filepath.Join(dir, "other-rig", "rows.csv")) {
+		t.Error("A plain client picked the host directory")
+	}
+	if dat, _ := os.ReadFile(filepath.Join(dir, "rows.csv")); string(dat) != "1\n" {
+		t.Errorf("Expected the row in the server's own directory, actual %q", dat)
+	}
+}
+
+func TestHostDir(t *testing.T) {
+	for host, expected := range map[string]string{"rig-7": "rig-7", "../etc": ".._etc", "..": "_", `a\b`: "a_b"} {
+		if actual := hostDir(host); actual != expected {
+			t.Errorf("%q: expected %q, actual %q", host, expected, actual)
+		}
+	}
+}
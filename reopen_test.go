@@ -0,0 +1,92 @@
+package socketlogger
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReopenLogFiles(t *testing.T) {
+	dir := t.TempDir()
+	server := NewTcpLoggerServer()
+	server.SetLogFile(dir, "app.log")
+	jsonSink, err := NewJSONFileSink(filepath.Join(dir, "app.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.AddSink(jsonSink)
+	logger := connectLogger(t, bindLocal(t, server))
+	sent := make(chan bool)
+	go func() {
+		for i := 0; i < 200; i++ {
+			logger.Log("message %d", i)
+			time.Sleep(time.Millisecond)
+		}
+		sent <- true
+	}()
+
+	// What logrotate does with postrotate sending SIGHUP
+	waitForLog(t, filepath.Join(dir, "app.log"), "-- message 10"+string(reset))
+	for _, name := range []string{"app.log", "app.jsonl"} {
+		os.Rename(filepath.Join(dir, name), filepath.Join(dir, name+".1"))
+	}
+	if err := server.Reopen(); err != nil {
+		t.Fatal(err)
+	}
+	<-sent
+	logger.Disconnect()
+	server.Shutdown()
+
+	for name, pattern := range map[string]string{"app.log": "-- message %d" + string(reset), "app.jsonl": `"message":"message %d"`} {
+		before, _ := os.ReadFile(filepath.Join(dir, name+".1"))
+		after, _ := os.ReadFile(filepath.Join(dir, name))
+		if !strings.Contains(string(before), "message 0") || !strings.Contains(string(after), "message 199") {
+			t.Errorf("Expected %s to be reopened, before:\n%s\nafter:\n%s", name, before, after)
+		}
+		for i := 0; i < 200; i++ {
+			if n := strings.Count(string(before)+string(after), fmt.Sprintf(pattern, i)); n != 1 {
+				t.Errorf("Expected message %d once in %s, actual %d times", i, name, n)
+			}
+		}
+	}
+}
+
+func TestReopenCsvFiles(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "data.csv")
+	server := NewTcpCsvServer()
+	server.SetOutputCsvDirectory(dir)
+	client := NewTcpCsvClient()
+	client.Connect(Connection{}, bindLocal(t, server))
+	client.NewCsvFile("data.csv", []interface{}{"step"})
+	client.AppendRow("data.csv", []interface{}{"before"})
+	waitForLog(t, path, "before")
+
+	// logrotate with copytruncate off and create on: a new empty file is made before the signal
+	os.Rename(path, path+".1")
+	os.WriteFile(path, nil, 0o666)
+	if err := server.Reopen(); err != nil {
+		t.Fatal(err)
+	}
+	client.AppendRow("data.csv", []interface{}{"after"})
+	waitForLog(t, path, "after")
+
+	// Removed without a signal, the next row starts a new file
+	os.Rename(path, path+".2")
+	client.AppendRow("data.csv", []interface{}{"recreated"})
+	client.Disconnect()
+	server.Shutdown()
+
+	for name, expected := range map[string]string{
+		"data.csv.1": "step\nbefore\n",
+		"data.csv.2": "step\nafter\n",
+		"data.csv":   "step\nrecreated\n",
+	} {
+		if dat, _ := os.ReadFile(filepath.Join(dir, name)); string(dat) != expected {
+			t.Errorf("Expected %s to be %q, actual %q", name, expected, dat)
+		}
+	}
+}
@@ -0,0 +1,319 @@
+package socketlogger
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Rotation says when a RotatingFile moves on to a new file and how long it keeps the old ones
+type Rotation struct {
+	MaxSize  int64         // Bytes in a file before it is rotated, 0 for no limit
+	Interval time.Duration // Rotates on every multiple of it in local time, e.g. time.Hour or 24*time.Hour. 0 for never
+	Compress bool          // Gzips rotated files
+	MaxFiles int           // Rotated files kept, 0 keeps all of them
+	MaxAge   time.Duration // Rotated files older than this are removed, 0 keeps them forever
+}
+
+// Layout of the time added to the names of rotated files
+const rotatedTimeFormat = "2006-01-02T15:04:05.000"
+
+// RotatingFile is a log file that is renamed once it gets too big or too old, and written again from
+// empty. A file rotated at 21:00 from logs/app.log is logs/app-2021-09-13T21:00:00.000.log, with .gz
+// added when compressed. Safe to share between servers
+type RotatingFile struct {
+	mu       sync.Mutex
+	path     string
+	rotation Rotation
+	file     *os.File // nil when opening it after a rotation failed, tried again on the next write
+	closed   bool
+	size     int64
+	next     time.Time // When Interval rotates the file next
+
+	lastStamp string // Time in the name of the last rotated file
+	lastCount int    // Files rotated at lastStamp
+
+	mill    sync.Mutex     // Compresses and prunes one rotated file at a time
+	milling sync.WaitGroup // Close waits for them
+	millErr error          // Returned by Close
+}
+
+// OpenRotatingFile appends to the file at path, which is rotated on the first write if it is already
+// due. Its directory is created if needed
+func OpenRotatingFile(path string, rotation Rotation) (*RotatingFile, error) {
+	r := &RotatingFile{
+		path:     filepath.Clean(path), // Compared with the names of rotated files, which are clean
+		rotation: rotation,
+	}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *RotatingFile) open() error {
+	file, err := openLogFile(r.path)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	r.file = file
+	r.size = info.Size()
+	started := time.Now()
+	if r.size > 0 {
+		started = info.ModTime() // Left from an earlier run
+	}
+	r.next = nextRotation(started, r.rotation.Interval)
+	return nil
+}
+
+// The first multiple of interval after t in t's time zone, so daily files start at local midnight
+func nextRotation(t time.Time, interval time.Duration) time.Time {
+	if interval <= 0 {
+		return time.Time{}
+	}
+	_, offset := t.Zone()
+	shift := time.Duration(offset) * time.Second
+	return t.Add(shift).Truncate(interval).Add(interval).Add(-shift)
+}
+
+func (r *RotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return 0, os.ErrClosed
+	} else if r.file == nil {
+		if err := r.open(); err != nil {
+			return 0, err
+		}
+	}
+
+	due := r.rotation.Interval > 0 && !time.Now().Before(r.next)
+	full := r.rotation.MaxSize > 0 && r.size > 0 && r.size+int64(len(p)) > r.rotation.MaxSize
+	if due || full {
+		if err := r.rotate(); err != nil && r.file == nil {
+			return 0, err
+		}
+	}
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// Rotate moves on to a new file now
+func (r *RotatingFile) Rotate() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return os.ErrClosed
+	} else if r.file == nil {
+		return r.open() // Nothing to rename, the last rotation did that
+	}
+	return r.rotate()
+}
+
+// Renames the file and opens a new one. The file is opened again even when the rename fails, so
+// messages keep being written. When opening fails, the next write tries again
+func (r *RotatingFile) rotate() error {
+	r.file.Close()
+	r.file = nil
+	rotated := r.rotatedName(time.Now())
+	renameErr := os.Rename(r.path, rotated)
+	if err := r.open(); err != nil {
+		return err
+	}
+	if renameErr != nil {
+		return renameErr
+	}
+
+	r.milling.Add(1)
+	go r.finish(rotated)
+	return nil
+}
+
+// Splits the file name into the part before the time added on rotation and the part after
+func (r *RotatingFile) nameParts() (string, string) {
+	ext := filepath.Ext(r.path)
+	return strings.TrimSuffix(r.path, ext) + "-", ext
+}
+
+// Counts up within a millisecond, so names of pruned files are not used again
+func (r *RotatingFile) rotatedName(t time.Time) string {
+	prefix, ext := r.nameParts()
+	stamp := t.Format(rotatedTimeFormat)
+	if stamp != r.lastStamp {
+		r.lastStamp, r.lastCount = stamp, 0
+	}
+	for {
+		name := prefix + stamp
+		if r.lastCount > 0 {
+			name += "." + strconv.Itoa(r.lastCount)
+		}
+		r.lastCount++
+		if !taken(name+ext) && !taken(name+ext+".gz") {
+			return name + ext
+		}
+	}
+}
+
+// Only a file that is there, so a path that can't be checked at all doesn't keep rotatedName counting
+func taken(path string) bool {
+	_, err := os.Lstat(path)
+	return err == nil
+}
+
+// Compresses the rotated file and removes the ones past the retention, away from the writers
+func (r *RotatingFile) finish(rotated string) {
+	defer r.milling.Done()
+	r.mill.Lock()
+	defer r.mill.Unlock()
+
+	if r.rotation.Compress {
+		if err := compressFile(rotated); err != nil && !os.IsNotExist(err) { // Pruned by a later rotation
+			r.millErr = err
+		}
+	}
+	if err := r.prune(); err != nil {
+		r.millErr = err
+	}
+}
+
+func compressFile(path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	out, err := os.OpenFile(path+".gz", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, info.Mode())
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(out)
+	zw.Name = filepath.Base(path)
+	zw.ModTime = info.ModTime()
+	_, err = io.Copy(zw, in)
+	if err == nil {
+		err = zw.Close()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path + ".gz")
+		return err
+	}
+	os.Chtimes(path+".gz", info.ModTime(), info.ModTime()) // Keeps MaxAge counting from the rotation
+	return os.Remove(path)
+}
+
+// Removes rotated files beyond MaxFiles and older than MaxAge
+func (r *RotatingFile) prune() error {
+	if r.rotation.MaxFiles <= 0 && r.rotation.MaxAge <= 0 {
+		return nil
+	}
+	prefix, ext := r.nameParts()
+	entries, err := os.ReadDir(filepath.Dir(r.path))
+	if err != nil {
+		return err
+	}
+
+	var rotated []string
+	keys := make(map[string]string)
+	for _, entry := range entries {
+		name := filepath.Join(filepath.Dir(r.path), entry.Name())
+		if key, ok := r.rotatedKey(name, prefix, ext); ok {
+			rotated = append(rotated, name)
+			keys[name] = key
+		}
+	}
+	sort.Slice(rotated, func(i, j int) bool {
+		return keys[rotated[i]] > keys[rotated[j]] // Newest first
+	})
+
+	var firstErr error
+	for i, name := range rotated {
+		info, err := os.Stat(name)
+		if err != nil {
+			continue
+		}
+		tooMany := r.rotation.MaxFiles > 0 && i >= r.rotation.MaxFiles
+		tooOld := r.rotation.MaxAge > 0 && time.Since(info.ModTime()) > r.rotation.MaxAge
+		if tooMany || tooOld {
+			if err := os.Remove(name); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+// Sorts files named by rotatedName oldest first, false for other files that happen to start with the
+// same name
+func (r *RotatingFile) rotatedKey(name, prefix, ext string) (string, bool) {
+	name = strings.TrimSuffix(name, ".gz")
+	if !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, ext) || len(name) < len(prefix)+len(rotatedTimeFormat)+len(ext) {
+		return "", false
+	}
+	stamp := name[len(prefix) : len(prefix)+len(rotatedTimeFormat)]
+	if _, err := time.Parse(rotatedTimeFormat, stamp); err != nil {
+		return "", false
+	}
+	n := 0
+	if count := strings.TrimSuffix(name[len(prefix)+len(stamp):], ext); count != "" {
+		var err error
+		if n, err = strconv.Atoi(strings.TrimPrefix(count, ".")); err != nil || count[0] != '.' {
+			return "", false
+		}
+	}
+	return fmt.Sprintf("%s.%09d", stamp, n), true
+}
+
+// Reopen opens the file again by its path, e.g. after something else moved it
+func (r *RotatingFile) Reopen() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return os.ErrClosed
+	}
+	old := r.file
+	if err := r.open(); err != nil {
+		return err
+	} else if old == nil {
+		return nil
+	}
+	return old.Close()
+}
+
+// Close closes the file and waits for rotated files to be compressed and pruned
+func (r *RotatingFile) Close() error {
+	r.mu.Lock()
+	var err error
+	if r.file != nil {
+		err = r.file.Close()
+		r.file = nil
+	}
+	r.closed = true
+	r.mu.Unlock()
+
+	r.milling.Wait()
+	if err == nil {
+		err = r.millErr
+	}
+	return err
+}
@@ -0,0 +1,210 @@
+package socketlogger
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func rotatedFiles(t *testing.T, dir string) []string {
+	matches, err := filepath.Glob(filepath.Join(dir, "app-*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return matches
+}
+
+func TestRotateBySize(t *testing.T) {
+	dir := t.TempDir()
+	file, err := OpenRotatingFile(filepath.Join(dir, "app.log"), Rotation{
+		MaxSize:  100,
+		Compress: true,
+		MaxFiles: 2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	line := strings.Repeat("x", 39) + "\n"
+	for i := 0; i < 10; i++ {
+		file.Write([]byte(line))
+	}
+	if err := file.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 2 lines per file, the last 2 full files are kept
+	rotated := rotatedFiles(t, dir)
+	if len(rotated) != 2 {
+		t.Fatalf("Expected 2 rotated files, actual %v", rotated)
+	}
+	for _, name := range rotated {
+		if !strings.HasSuffix(name, ".log.gz") {
+			t.Errorf("Expected %s to be compressed", name)
+		}
+		gz, _ := os.Open(name)
+		defer gz.Close()
+		zr, err := gzip.NewReader(gz)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dat, _ := io.ReadAll(zr)
+		if string(dat) != line+line {
+			t.Errorf("Unexpected contents of %s: %q", name, dat)
+		}
+	}
+	if dat, _ := os.ReadFile(filepath.Join(dir, "app.log")); string(dat) != line+line {
+		t.Errorf("Unexpected contents of the current file: %q", dat)
+	}
+}
+
+// Rotated files are found again when the path isn't clean
+func TestRotateRelativePath(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := filepath.Rel(wd, t.TempDir())
+	if err != nil {
+		t.Skip(err)
+	}
+	file, err := OpenRotatingFile("./"+filepath.ToSlash(dir)+"/logs/app.log", Rotation{MaxSize: 10, MaxFiles: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 4; i++ {
+		file.Write([]byte("0123456789\n"))
+	}
+	if err := file.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if rotated := rotatedFiles(t, filepath.Join(dir, "logs")); len(rotated) != 1 {
+		t.Errorf("Expected 1 rotated file, actual %v", rotated)
+	}
+}
+
+// A file that could not be opened after a rotation is opened again by the next write
+func TestRotateOpenFails(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+	file, err := OpenRotatingFile(path, Rotation{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Write([]byte("before\n"))
+	file.path = filepath.Join(dir, "bad\x00.log")
+	if err := file.Rotate(); err == nil {
+		t.Fatal("Expected opening the bad path to fail")
+	}
+	file.path = path
+	if _, err := file.Write([]byte("after\n")); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	if dat, _ := os.ReadFile(path); string(dat) != "before\nafter\n" {
+		t.Errorf("Unexpected contents %q", dat)
+	}
+	if _, err := file.Write([]byte("closed\n")); err != os.ErrClosed {
+		t.Errorf("Expected %v after Close, actual %v", os.ErrClosed, err)
+	}
+}
+
+func TestRotateByInterval(t *testing.T) {
+	dir := t.TempDir()
+	file, err := OpenRotatingFile(filepath.Join(dir, "app.log"), Rotation{Interval: 200 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Write([]byte("first\n"))
+	time.Sleep(250 * time.Millisecond)
+	file.Write([]byte("second\n"))
+	file.Close()
+
+	rotated := rotatedFiles(t, dir)
+	if len(rotated) != 1 {
+		t.Fatalf("Expected 1 rotated file, actual %v", rotated)
+	}
+	if dat, _ := os.ReadFile(rotated[0]); string(dat) != "first\n" {
+		t.Errorf("Unexpected contents of %s: %q", rotated[0], dat)
+	}
+	if dat, _ := os.ReadFile(filepath.Join(dir, "app.log")); string(dat) != "second\n" {
+		t.Errorf("Unexpected contents of the current file: %q", dat)
+	}
+}
+
+func TestRotateMaxAge(t *testing.T) {
+	dir := t.TempDir()
+	old := filepath.Join(dir, "app-2021-09-13T21:00:00.000.log.gz")
+	unrelated := filepath.Join(dir, "app-notes.log")
+	for _, name := range []string{old, unrelated} {
+		os.WriteFile(name, nil, 0o666)
+		os.Chtimes(name, time.Now().Add(-48*time.Hour), time.Now().Add(-48*time.Hour))
+	}
+
+	file, err := OpenRotatingFile(filepath.Join(dir, "app.log"), Rotation{MaxAge: 24 * time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Write([]byte("first\n"))
+	if err := file.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	if fileExists(old) {
+		t.Errorf("Expected %s to be removed", old)
+	}
+	if !fileExists(unrelated) {
+		t.Errorf("Expected %s to be left alone", unrelated)
+	}
+	if rotated := rotatedFiles(t, dir); len(rotated) != 2 {
+		t.Errorf("Expected the new rotated file and the unrelated one, actual %v", rotated)
+	}
+}
+
+func TestRotatingServers(t *testing.T) {
+	dir := t.TempDir()
+	file, err := OpenRotatingFile(filepath.Join(dir, "app.log"), Rotation{MaxSize: 200})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var servers []LoggerServer
+	for n := 0; n < 2; n++ {
+		server := NewTcpLoggerServer()
+		server.SetOutput(file)
+		servers = append(servers, server)
+
+		logger := connectLogger(t, bindLocal(t, server))
+		for i := 0; i < 5; i++ {
+			logger.Log("message %d to server %d", i, n)
+		}
+		logger.Disconnect()
+	}
+	for _, server := range servers {
+		server.Shutdown()
+	}
+	file.Close()
+
+	var all string
+	for _, name := range append(rotatedFiles(t, dir), filepath.Join(dir, "app.log")) {
+		dat, _ := os.ReadFile(name)
+		if len(dat) > 200 && strings.Count(string(dat), "\n") > 1 {
+			t.Errorf("%s is over the limit: %d bytes", name, len(dat))
+		}
+		all += string(dat)
+	}
+	for n := 0; n < 2; n++ {
+		for i := 0; i < 5; i++ {
+			expected := fmt.Sprintf("message %d to server %d", i, n)
+			if !strings.Contains(all, expected) {
+				t.Errorf("%q missing from the rotated files", expected)
+			}
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package socketlogger
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	maxTrackedGaps    int           = 10000
+	maxTrackedSenders int           = 10000            // Sessions remembered before the least recently seen is dropped
+	senderIdleTimeout time.Duration = 30 * time.Minute // Sessions not heard from for this long are dropped
+)
+
+// Delivery totals for one client session, see Server.SenderStats
+type SenderStats struct {
+	Addr     string // host:port the messages came from
+	Session  string // Random ID the client picks every time it connects
+	Received uint64
+	Lost     uint64 // Messages that were skipped in the sequence and never arrived
+}
+
+// Sent back to a reliable UDP client for every numbered message that arrives
+type ackMessage struct {
+	Session string      `json:"ack"`
+	Ranges  [][2]uint64 `json:"ranges"` // Inclusive ranges of sequence numbers
+}
+
+func (a *ackMessage) acks(seq uint64) bool {
+	for _, r := range a.Ranges {
+		if seq >= r[0] && seq <= r[1] {
+			return true
+		}
+	}
+	return false
+}
+
+type senderState struct {
+	stats   SenderStats
+	floor   uint64              // Sequence numbers below this were never tracked, so replays can't be spotted
+	next    uint64              // Sequence number expected next
+	missing map[uint64]struct{} // Skipped sequence numbers that may still arrive late
+	seen    time.Time           // When the session last sent a message
+}
+
+// Watches the sequence numbers of every session for gaps and replays. Clients start a new session every
+// time they connect, so sessions that went quiet are forgotten
+type sequenceTracker struct {
+	lock    sync.Mutex
+	senders map[string]*senderState
+	idle    time.Duration // Sessions quiet for longer are dropped
+	limit   int           // Sessions kept at most
+	swept   time.Time     // When idle sessions were last dropped
+}
+
+func newSequenceTracker() *sequenceTracker {
+	return &sequenceTracker{
+		senders: make(map[string]*senderState),
+		idle:    senderIdleTimeout,
+		limit:   maxTrackedSenders,
+		swept:   time.Now(),
+	}
+}
+
+// Records seq from a session. Returns how many messages were found missing because of it, and whether it
+// has already been seen. Messages without a session or sequence number are not tracked
+func (t *sequenceTracker) track(from net.Addr, session string, seq uint64) (lost uint64, duplicate bool) {
+	if session == "" || seq == 0 {
+		return 0, false
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	sender := t.sender(from, session, seq)
+	switch {
+	case seq == sender.next:
+		sender.next++
+	case seq > sender.next:
+		lost = seq - sender.next
+		first := sender.next
+		if len(sender.missing)+int(lost) > maxTrackedGaps {
+			// Too many holes to remember, only the most recent ones can still be filled
+			sender.missing = make(map[uint64]struct{})
+			if lost > uint64(maxTrackedGaps) {
+				first = seq - uint64(maxTrackedGaps)
+			}
+			sender.floor = first
+		}
+		for missed := first; missed < seq; missed++ {
+			sender.missing[missed] = struct{}{}
+		}
+		sender.stats.Lost += lost
+		sender.next = seq + 1
+	case seq < sender.floor:
+		// Sent before tracking started, deliver it rather than risk dropping it
+	default:
+		if _, late := sender.missing[seq]; !late {
+			return 0, true
+		}
+		delete(sender.missing, seq)
+		sender.stats.Lost--
+	}
+	sender.stats.Received++
+	return lost, false
+}
+
+// Returns the ranges of sequence numbers that are known to have arrived: everything up to the first
+// hole, and seq itself
+func (t *sequenceTracker) received(from net.Addr, session string, seq uint64) [][2]uint64 {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	sender := t.sender(from, session, seq)
+
+	upTo := sender.next - 1
+	for missed := range sender.missing {
+		if missed <= upTo {
+			upTo = missed - 1
+		}
+	}
+	ranges := [][2]uint64{{seq, seq}}
+	if upTo >= sender.floor && upTo > 0 {
+		ranges = append(ranges, [2]uint64{sender.floor, upTo})
+	}
+	return ranges
+}
+
+// Must hold the lock. The first message seen from a session is the baseline, anything sent
+// before the server was listening is not counted as lost
+func (t *sequenceTracker) sender(from net.Addr, session string, seq uint64) *senderState {
+	addr := ""
+	if from != nil {
+		addr = from.String()
+	}
+	key := addr + "/" + session
+	now := time.Now()
+	sender, ok := t.senders[key]
+	if !ok {
+		t.evict(now)
+		sender = &senderState{
+			stats:   SenderStats{Addr: addr, Session: session},
+			floor:   seq,
+			next:    seq,
+			missing: make(map[uint64]struct{}),
+		}
+		t.senders[key] = sender
+	}
+	sender.seen = now
+	return sender
+}
+
+// Must hold the lock. Makes room for a new session: drops the idle ones every so often, and the least
+// recently seen one when there are still too many
+func (t *sequenceTracker) evict(now time.Time) {
+	if now.Sub(t.swept) >= t.idle/10 {
+		t.swept = now
+		for key, sender := range t.senders {
+			if now.Sub(sender.seen) > t.idle {
+				delete(t.senders, key)
+			}
+		}
+	}
+	for len(t.senders) >= t.limit {
+		oldest := ""
+		for key, sender := range t.senders {
+			if oldest == "" || sender.seen.Before(t.senders[oldest].seen) {
+				oldest = key
+			}
+		}
+		delete(t.senders, oldest)
+	}
+}
+
+func (t *sequenceTracker) stats() []SenderStats {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	stats := make([]SenderStats, 0, len(t.senders))
+	for _, sender := range t.senders {
+		stats = append(stats, sender.stats)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Addr != stats[j].Addr {
+			return stats[i].Addr < stats[j].Addr
+		}
+		return stats[i].Session < stats[j].Session
+	})
+	return stats
+}
+
+func newSessionID() string {
+	id := make([]byte, 8)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
@@ -0,0 +1,182 @@
+package socketlogger
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSequenceTracker(t *testing.T) {
+	tracker := newSequenceTracker()
+	from := &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 9000}
+
+	steps := []struct {
+		seq       uint64
+		lost      uint64
+		duplicate bool
+	}{
+		{1, 0, false},
+		{2, 0, false},
+		{5, 2, false}, // 3 and 4 missing
+		{3, 0, false}, // late, no longer lost
+		{3, 0, true},
+		{5, 0, true},
+		{6, 0, false},
+	}
+	for i, step := range steps {
+		lost, duplicate := tracker.track(from, "session", step.seq)
+		if lost != step.lost || duplicate != step.duplicate {
+			t.Errorf("Step %d, seq %d. Expected lost %d duplicate %v, actual lost %d duplicate %v", i, step.seq, step.lost, step.duplicate, lost, duplicate)
+		}
+	}
+
+	stats := tracker.stats()
+	if len(stats) != 1 || stats[0].Received != 5 || stats[0].Lost != 1 || stats[0].Addr != from.String() {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+
+	if _, duplicate := tracker.track(from, "", 0); duplicate || len(tracker.stats()) != 1 {
+		t.Error("Messages without a sequence number should not be tracked")
+	}
+}
+
+func TestSequenceTrackerForgets(t *testing.T) {
+	tracker := newSequenceTracker()
+	tracker.limit = 2
+	from := &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 9000}
+
+	tracker.track(from, "quiet", 1)
+	tracker.track(from, "busy", 1)
+	tracker.track(from, "newer", 1)
+	if stats := tracker.stats(); len(stats) != 2 || stats[0].Session != "busy" || stats[1].Session != "newer" {
+		t.Errorf("Expected the least recently seen session to be dropped, actual %+v", stats)
+	}
+
+	tracker.limit = 3
+	tracker.senders[from.String()+"/busy"].seen = time.Now().Add(-2 * senderIdleTimeout)
+	tracker.swept = time.Time{}
+	tracker.track(from, "latest", 1)
+	if stats := tracker.stats(); len(stats) != 2 || stats[0].Session != "latest" || stats[1].Session != "newer" {
+		t.Errorf("Expected the idle session to be dropped, actual %+v", stats)
+	}
+}
+
+func TestReliableUDP(t *testing.T) {
+	dir := t.TempDir()
+	server := NewUdpCsvServer()
+	server.SetOutputCsvDirectory(dir)
+	server.SetReliable(true)
+
+	// Drops every third datagram to the server and sends every other one twice
+	proxy := lossyProxy(t, bindLocal(t, server))
+	defer proxy.Close()
+
+	client := NewUdpCsvClient()
+	client.SetReliable(true)
+	client.Connect(Connection{
+		Addr: "127.0.0.1",
+		Port: 0,
+	}, connectionOf(proxy.LocalAddr()))
+	expected := make(map[string]bool)
+	for i := 0; i < 50; i++ {
+		client.AppendRow("reliable.csv", []interface{}{i})
+		expected[fmt.Sprint(i)] = true
+	}
+	client.Disconnect()
+	server.Shutdown()
+
+	dat, _ := os.ReadFile(filepath.Join(dir, "reliable.csv"))
+	rows := strings.Fields(string(dat))
+	for _, row := range rows {
+		if !expected[row] {
+			t.Errorf("Row %s was written more than once", row)
+		}
+		delete(expected, row)
+	}
+	if len(expected) > 0 {
+		t.Errorf("%d rows never arrived: %v", len(expected), expected)
+	}
+}
+
+func TestReliableClientUnreliableServer(t *testing.T) {
+	dir := t.TempDir()
+	server := NewUdpCsvServer()
+	server.SetOutputCsvDirectory(dir)
+
+	client := NewUdpCsvClient()
+	client.SetReliable(true)
+	client.Connect(Connection{Addr: "127.0.0.1"}, bindLocal(t, server))
+	for i := 0; i < ackWindow+100; i++ {
+		client.AppendRow("unacked.csv", []interface{}{i})
+		if i%100 == 0 {
+			time.Sleep(time.Millisecond) // Keeps the burst within the socket buffers
+		}
+	}
+	client.Disconnect()
+	server.Shutdown()
+
+	dat, _ := os.ReadFile(filepath.Join(dir, "unacked.csv"))
+	if !strings.Contains(string(dat), fmt.Sprintf("\n%d\n", ackWindow+99)) {
+		t.Errorf("Expected the client to stop waiting for acks and send the rest, %d rows arrived", strings.Count(string(dat), "\n"))
+	}
+}
+
+func TestUDPLossReported(t *testing.T) {
+	dir := t.TempDir()
+	server := NewUdpLoggerServer()
+	server.SetLogFile(dir, "loss.log")
+	remote := bindLocal(t, server)
+	defer server.Shutdown()
+
+	conn, err := net.Dial("udp", fmt.Sprintf("127.0.0.1:%d", remote.Port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	for _, seq := range []int{1, 2, 6} {
+		fmt.Fprintf(conn, `{"caller":"sequence_test.go","level":0,"message":"seq %d","session":"abc","seq":%d}`, seq, seq)
+	}
+	waitForLog(t, filepath.Join(dir, "loss.log"), "Lost 3 messages from "+conn.LocalAddr().String())
+
+	stats := server.SenderStats()
+	if len(stats) != 1 || stats[0].Session != "abc" || stats[0].Received != 3 || stats[0].Lost != 3 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+}
+
+func lossyProxy(t *testing.T, to Connection) net.PacketConn {
+	proxy, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &net.UDPAddr{IP: net.ParseIP(to.Addr), Port: to.Port}
+
+	go func() {
+		var client net.Addr
+		buf := make([]byte, maxDatagramSize)
+		for count := 1; ; count++ {
+			n, from, err := proxy.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if from.String() == server.String() {
+				proxy.WriteTo(buf[:n], client) // Acks always make it back
+				continue
+			}
+
+			client = from
+			if count%3 == 0 {
+				continue
+			}
+			proxy.WriteTo(buf[:n], server)
+			if count%2 == 0 {
+				proxy.WriteTo(buf[:n], server)
+			}
+		}
+	}()
+	return proxy
+}
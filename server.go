@@ -1,23 +1,34 @@
 package socketlogger
 
 import (
-	"bufio"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
 	"net"
+	"sync"
 	"time"
 )
 
+// How long Shutdown keeps reading what clients already sent before it closes their sockets
+const shutdownGrace time.Duration = 100 * time.Millisecond
+
 type Server interface {
 	Bind(c Connection) error
 	Shutdown()
+	Addr() Connection
+	SetTLSConfig(config *tls.Config) error
+	SetFraming(framing Framing) error
+	SenderStats() []SenderStats
+	SetReliable(reliable bool) error
+	SetAcceptRelayed(accept bool) error
 
 	start()
 	buildSocket(c Connection) (net.Conn, error)
 	listenForMsgsOnSocket(sock net.Conn, msgs chan SocketMessage)
 	getMessageType() SocketMessage
+	decode(frame []byte) (SocketMessage, error)
 	init(interface{})
 	write(chan SocketMessage) // Either log file, csv file or console, implemented in server type
 	setFlushChannel(chan bool)
@@ -29,6 +40,14 @@ type server struct {
 	this         interface{}
 	closeSockets chan bool // This channel will notify to close the sockets
 	flushed      chan bool // This makes Shutdown() blocking, allowing everything to be written to console/log file
+	msgsLock     sync.RWMutex
+	closed       bool // msgs has been closed, guarded by msgsLock
+	sequences    *sequenceTracker
+	reliable     bool           // Acknowledge numbered messages, only supported over UDP
+	relayed      bool           // Keep the host relaying servers put on messages
+	cleanup      []func()       // Run by Shutdown once the sockets are closed
+	readers      sync.WaitGroup // Goroutines reading sockets, Shutdown waits for them before closing msgs
+	addr         Connection     // Where the server is listening, set by buildSocket
 }
 
 func (s *server) Bind(c Connection) error {
@@ -42,10 +61,37 @@ func (s *server) Bind(c Connection) error {
 	}
 }
 
+// Shutdown stops accepting clients and writes everything they have already sent, giving open sockets
+// a moment to deliver it, before it returns
 func (s *server) Shutdown() {
 	close(s.closeSockets)
+	s.readers.Wait()
+	for _, cleanup := range s.cleanup {
+		cleanup()
+	}
+	s.msgsLock.Lock()
+	s.closed = true
 	close(s.msgs) // Notifies writer to finish writing
-	<-s.flushed   // Waits for writer to flush all data
+	s.msgsLock.Unlock()
+	<-s.flushed // Waits for writer to flush all data
+}
+
+// Addr returns where the server is listening, with the port the system picked when Bind was given port 0
+func (s *server) Addr() Connection {
+	return s.addr
+}
+
+// The address clients connect to, taken from the socket so that port 0 is resolved
+func connectionOf(addr net.Addr) Connection {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return Connection{Addr: a.IP.String(), Port: a.Port}
+	case *net.UDPAddr:
+		return Connection{Addr: a.IP.String(), Port: a.Port}
+	case *net.UnixAddr:
+		return Connection{Path: a.Name}
+	}
+	return Connection{}
 }
 
 func (s *server) init(i interface{}) {
@@ -56,58 +102,177 @@ func (s *server) init(i interface{}) {
 		s.this = i
 		s.closeSockets = make(chan bool)
 		s.flushed = make(chan bool)
+		s.sequences = newSequenceTracker()
+	}
+}
+
+// SenderStats returns how many messages were received and lost from every client session that
+// numbers its messages. UDP clients do, so this shows when UDP logs are incomplete. Sessions not heard
+// from for 30 minutes are left out, and only the 10000 most recent are kept
+func (s *server) SenderStats() []SenderStats {
+	return s.sequences.stats()
+}
+
+// SetReliable makes the server acknowledge every numbered message so the client can retransmit
+// anything that was lost. Only needed over UDP, stream sockets are already reliable
+func (s *server) SetReliable(reliable bool) error {
+	return fmt.Errorf("reliable mode is only supported by UDP servers")
+}
+
+// SetAcceptRelayed keeps the host name that relaying servers put on messages, which also picks the directory
+// relayed csv rows go to. Off by default so a client can't pick its host, only turn it on for a server that
+// other socketlogger servers relay to
+func (s *server) SetAcceptRelayed(accept bool) error {
+	s.relayed = accept
+	return nil
+}
+
+// Replaces what only the server may fill in on a message from a client
+func (s *server) vouch(msg SocketMessage, identity string) {
+	if e, ok := msg.(enveloped); ok {
+		env := e.env()
+		env.Identity = identity // Never trust an identity sent by the client
+		if !s.relayed {
+			env.Host = ""
+		}
 	}
 }
 
 func (s *server) start() {
 	s.this.(Server).setFlushChannel(s.flushed)
 	go s.this.(Server).write(s.msgs)
-	go s.listenForMsgsOnSocket(s.comms.sock, s.msgs)
+	s.readers.Add(1)
+	go func() {
+		defer s.readers.Done()
+		s.this.(Server).listenForMsgsOnSocket(s.comms.sock, s.msgs)
+	}()
 }
 
 func (s *server) listenForMsgsOnSocket(sock net.Conn, msgs chan SocketMessage) {
-	if sock != nil {
-		reader := bufio.NewReaderSize(sock, bufSize)
-		dec := json.NewDecoder(reader)
-		inst, ok := s.this.(Server)
-		if !ok {
-			panic(fmt.Errorf("server is not a Sever type. Type %T", inst))
+	if sock == nil {
+		return
+	}
+	done := make(chan bool)
+	defer close(done)
+	go s.closeOnShutdown(sock, done)
+
+	identity, err := peerIdentity(sock)
+	if err != nil {
+		if !s.shuttingDown() {
+			s.submit(newLogMessage(MessageLevelErr, "TLS handshake with %s failed: %v", sock.RemoteAddr(), err))
 		}
+		sock.Close()
+		return
+	} else if s.shuttingDown() {
+		sock.SetReadDeadline(time.Now().Add(shutdownGrace)) // The handshake cleared the one closeOnShutdown set
+	}
 
-		decoded := make(chan SocketMessage, 100)
-		running := true
-		socketDisconnected := make(chan bool)
-		go func() {
-			for {
-				msg := inst.getMessageType()
-				if err := dec.Decode(&msg); err != nil {
-					if running && err == io.EOF {
-						time.Sleep(50 * time.Nanosecond) // Make sure this message gets printed last
-						s.msgs <- newLogMessage(MessageLevelDbg, "Socket disconnected %s", sock.RemoteAddr())
-					} else if running {
-						s.msgs <- newLogMessage(MessageLevelErr, "ERROR!! %v, unexpected error: %v", err, running)
-					}
-					sock.Close()
-					break
-				} else {
-					decoded <- msg
-				}
-			}
-			socketDisconnected <- true
-		}()
+	err = s.readFrames(newFrameReader(sock, s.framing), sock.RemoteAddr(), identity, nil)
+	if err == io.EOF {
+		s.submit(newLogMessage(MessageLevelDbg, "Socket disconnected %s", sock.RemoteAddr()))
+	} else if s.shuttingDown() {
+		// Cut off by Shutdown, nothing to report
+	} else {
+		s.submit(newLogMessage(MessageLevelErr, "Closing %s, unexpected error: %v", sock.RemoteAddr(), err))
+	}
+	sock.Close()
+}
 
-	L:
-		for {
-			select {
-			case msg := <-decoded:
-				msgs <- msg
-			case <-s.closeSockets:
-				running = false
-				break L
+// Decodes every frame and hands the messages to the writer. Returns the error that ended the frames.
+// In reliable mode numbered messages are acknowledged over reply
+func (s *server) readFrames(frames frameReader, from net.Addr, identity string, reply net.PacketConn) error {
+	for {
+		frame, err := frames.next()
+		if bad, ok := err.(badFrame); ok {
+			s.submit(newLogMessage(MessageLevelWrn, "Skipping bad frame from %s: %v", from, bad))
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		msg, err := s.this.(Server).decode(frame)
+		if err != nil {
+			s.submit(newLogMessage(MessageLevelWrn, "Skipping malformed message from %s: %v", from, err))
+			continue
+		} else if msg == nil {
+			continue // Only part of a message, e.g. a GELF chunk
+		}
+		if from != nil {
+			received(msg, from.String())
+		}
+		s.vouch(msg, identity)
+		if e, ok := msg.(enveloped); ok {
+			env := e.env()
+			lost, duplicate := s.sequences.track(from, env.Session, env.Seq)
+			if s.reliable && reply != nil && env.Seq != 0 {
+				// Replays are acknowledged too, the first ack may have been the thing that was lost
+				ack, _ := json.Marshal(ackMessage{
+					Session: env.Session,
+					Ranges:  s.sequences.received(from, env.Session, env.Seq),
+				})
+				reply.WriteTo(ack, from)
+			}
+			if duplicate {
+				continue
+			} else if lost > 0 {
+				s.submit(newLogMessage(MessageLevelWrn, "Lost %d messages from %s", lost, from))
 			}
 		}
-		sock.Close()
-		<-socketDisconnected
+		s.submit(msg)
+	}
+}
+
+// Messages are JSON unless the server overrides this, e.g. syslog
+func (s *server) decode(frame []byte) (SocketMessage, error) {
+	msg := s.this.(Server).getMessageType()
+	return msg, json.Unmarshal(frame, msg)
+}
+
+// Sends msg to the writer, unless Shutdown has already closed it
+func (s *server) submit(msg SocketMessage) bool {
+	s.msgsLock.RLock()
+	defer s.msgsLock.RUnlock()
+	if s.closed {
+		return false
+	}
+	s.msgs <- msg
+	return true
+}
+
+func (s *server) onShutdown(cleanup func()) {
+	s.cleanup = append(s.cleanup, cleanup)
+}
+
+func (s *server) shuttingDown() bool {
+	select {
+	case <-s.closeSockets:
+		return true
+	default:
+		return false
+	}
+}
+
+// Unblocks reads on sock once the server has been shutting down for shutdownGrace, so what is
+// already on the way is still read
+func (s *server) closeOnShutdown(sock net.Conn, done chan bool) {
+	select {
+	case <-s.closeSockets:
+		sock.SetReadDeadline(time.Now().Add(shutdownGrace))
+	case <-done:
+	}
+}
+
+// Unblocks Accept once the server has been shutting down for shutdownGrace, so clients that connected
+// just before Shutdown are still read
+func (s *server) stopAccepting(listener net.Listener, done chan bool) {
+	select {
+	case <-s.closeSockets:
+		if l, ok := listener.(interface{ SetDeadline(time.Time) error }); ok {
+			l.SetDeadline(time.Now().Add(shutdownGrace))
+		} else {
+			listener.Close()
+		}
+	case <-done:
 	}
 }
 
@@ -115,15 +280,52 @@ type udpserver struct {
 	server
 }
 
+func (u *udpserver) SetTLSConfig(config *tls.Config) error {
+	return fmt.Errorf("TLS is not supported by the UDP server")
+}
+
+func (u *udpserver) SetReliable(reliable bool) error {
+	u.reliable = reliable
+	return nil
+}
+
+// Every datagram is read on its own, so a bad one never affects the next
+func (u *udpserver) listenForMsgsOnSocket(sock net.Conn, msgs chan SocketMessage) {
+	conn, ok := sock.(net.PacketConn)
+	if !ok {
+		panic(fmt.Errorf("udp server socket is not a net.PacketConn. Type: %T", sock))
+	}
+	done := make(chan bool)
+	defer close(done)
+	go u.closeOnShutdown(sock, done)
+
+	buf := make([]byte, maxDatagramSize)
+	splitter := newDatagramSplitter(u.framing)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if u.shuttingDown() || errors.Is(err, net.ErrClosed) {
+				return
+			}
+			u.submit(newLogMessage(MessageLevelErr, "Error reading datagram: %v", err))
+			continue
+		}
+
+		err = u.readFrames(splitter.frames(buf[:n]), addr, "", conn)
+		if err != io.EOF {
+			u.submit(newLogMessage(MessageLevelWrn, "Skipping rest of datagram from %s: %v", addr, err))
+		}
+	}
+}
+
 func (u *udpserver) buildSocket(c Connection) (net.Conn, error) {
 	sock, err := net.ListenUDP(udpProtocol, &net.UDPAddr{
 		IP:   net.ParseIP(c.Addr),
 		Port: c.Port,
 	})
-	if err != nil {
-		log.Println(newLogMessage(MessageLevelErr, "Could not create %s: %v", "UDP Server", err))
-	} else {
-		log.Println(newLogMessage(MessageLevelSuccess, "%s listening at %s", "UDP Server", sock.LocalAddr()))
+	if err == nil {
+		u.addr = connectionOf(sock.LocalAddr())
+		u.submit(newLogMessage(MessageLevelSuccess, "%s listening at %s", "UDP Server", sock.LocalAddr()))
 	}
 
 	return sock, err
@@ -135,25 +337,45 @@ type tcpserver struct {
 
 // Satisfies Server interface
 func (t *tcpserver) buildSocket(c Connection) (net.Conn, error) {
-	var err error
-	var listener net.Listener
-	listener, err = net.Listen("tcp", fmt.Sprintf("%v:%d", c.Addr, c.Port))
+	listener, err := net.Listen(tcpProtocol, fmt.Sprintf("%v:%d", c.Addr, c.Port))
 	if err == nil {
-		log.Println(newLogMessage(MessageLevelSuccess, "%s listening at %s:%d", "TCP Server", c.Addr, c.Port))
-		go func() {
-			defer listener.Close()
-			for {
-				// Listen for an incoming connection.
-				conn, err := listener.Accept()
-				if err != nil {
-					log.Println(newLogMessage(MessageLevelErr, "Error accepting: %v", err.Error()).String())
-					continue
-				}
-
-				go t.this.(Server).listenForMsgsOnSocket(conn, t.msgs)
-			}
-		}()
+		t.serve(listener, "TCP Server", listener.Addr().String())
 	}
 
 	return nil, err
 }
+
+// Accepts connections on listener until Shutdown, wrapping them in TLS if it is enabled
+func (t *tcpserver) serve(listener net.Listener, protocol, addr string) {
+	done := make(chan bool)
+	go t.stopAccepting(listener, done)
+	if t.tlsConfig != nil {
+		protocol = "TLS Server"
+		listener = tls.NewListener(listener, t.tlsConfig)
+	}
+	t.addr = connectionOf(listener.Addr())
+	t.submit(newLogMessage(MessageLevelSuccess, "%s listening at %s", protocol, addr))
+	t.readers.Add(1)
+	go func() {
+		defer t.readers.Done()
+		defer close(done)
+		defer listener.Close() // So the address can be bound again
+		for {
+			// Listen for an incoming connection.
+			conn, err := listener.Accept()
+			if err != nil {
+				if t.shuttingDown() || errors.Is(err, net.ErrClosed) {
+					return
+				}
+				t.submit(newLogMessage(MessageLevelErr, "Error accepting: %v", err.Error()))
+				continue
+			}
+
+			t.readers.Add(1)
+			go func() {
+				defer t.readers.Done()
+				t.this.(Server).listenForMsgsOnSocket(conn, t.msgs)
+			}()
+		}
+	}()
+}
@@ -1,50 +1,186 @@
 package main
 
 import (
+	"crypto/tls"
 	"flag"
 	"fmt"
+	"io"
 	"log"
+	"net/url"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"strconv"
 	"syscall"
 	"time"
 
 	"github.com/Ryan-Johnson-1315/socketlogger"
 )
 
-func startLogger(server socketlogger.LoggerServer, ip, dir, file string, port int, micro bool) {
-	server.SetLogFile(dir, file)
+func startLogger(server socketlogger.LoggerServer, c socketlogger.Connection, dir, file string, micro bool) {
+	switch {
+	case logJSON && rotatingLog != nil:
+		server.SetOutput(os.Stdout)
+		server.AddSink(socketlogger.NewJSONSink(rotatingLog))
+	case logJSON:
+		server.SetOutput(os.Stdout)
+		sink, err := socketlogger.NewJSONFileSink(filepath.Join(dir, file))
+		if err != nil {
+			panic(err)
+		}
+		server.AddSink(sink)
+	case rotatingLog != nil:
+		server.SetOutput(io.MultiWriter(os.Stdout, rotatingLog)) // Shared so the servers rotate it once
+	default:
+		server.SetLogFile(dir, file)
+	}
+	server.SetFraming(framing)
+	server.SetAcceptRelayed(acceptRelayed)
+	server.SetTimestamps(timestamps)
+	if err := server.SetClockSkewWarning(skewWarning); err != nil {
+		panic(err)
+	}
+	if liveTail != nil {
+		server.SetLiveTail(liveTail)
+	}
+	if syslogForwarder != nil {
+		server.SetSyslogForwarder(syslogForwarder)
+	}
+	if gelfForwarder != nil {
+		server.SetGelfForwarder(gelfForwarder)
+	}
+	if upstreamLog != nil {
+		server.SetUpstream(upstreamLog)
+	}
 
 	if micro {
 		server.SetTimeFlags(log.Ldate | log.Ltime)
 	} else {
 		server.SetTimeFlags(log.Ldate | log.Ltime | log.Lmicroseconds)
 	}
-	err := server.Bind(socketlogger.Connection{
-		Addr: ip,
-		Port: port,
-	})
+	err := server.Bind(c)
 	if err != nil {
 		panic(err)
 	}
 	servers = append(servers, server)
 }
 
-func startCsv(server socketlogger.CsvServer, ip, dir string, port int) {
+func startCsv(server socketlogger.CsvServer, c socketlogger.Connection, dir string) {
 	server.SetOutputCsvDirectory(dir)
+	server.SetFraming(framing)
+	server.SetAcceptRelayed(acceptRelayed)
+	if upstreamCsv != nil {
+		server.SetUpstream(upstreamCsv)
+	}
 
-	err := server.Bind(socketlogger.Connection{
-		Addr: ip,
-		Port: port,
-	})
+	err := server.Bind(c)
 	if err != nil {
 		panic(err)
 	}
 	servers = append(servers, server)
 }
 
-var servers []socketlogger.Server
+func setTLS(server socketlogger.Server) {
+	if tlsConfig != nil {
+		if err := server.SetTLSConfig(tlsConfig); err != nil {
+			panic(err)
+		}
+	}
+}
+
+var (
+	servers         []socketlogger.Server
+	tlsConfig       *tls.Config
+	framing         socketlogger.Framing
+	acceptRelayed   bool
+	timestamps      socketlogger.Timestamps
+	skewWarning     time.Duration
+	liveTail        *socketlogger.LiveTail
+	syslogForwarder socketlogger.SyslogClient
+	gelfForwarder   socketlogger.GelfClient
+	upstreamLog     socketlogger.LoggerClient
+	upstreamCsv     socketlogger.CsvClient
+	rotatingLog     *socketlogger.RotatingFile
+	logJSON         bool
+)
+
+// Opens the log and csv files again by their paths after logrotate moved them
+func reopen() {
+	if rotatingLog != nil {
+		if err := rotatingLog.Reopen(); err != nil {
+			log.Println("Could not reopen the log file:", err)
+		}
+	}
+	for _, server := range servers {
+		if r, ok := server.(interface{ Reopen() error }); ok {
+			if err := r.Reopen(); err != nil {
+				log.Println("Could not reopen files:", err)
+			}
+		}
+	}
+}
+
+// Takes hourly, daily or a duration like 30m
+func parseInterval(interval string) (time.Duration, error) {
+	switch interval {
+	case "":
+		return 0, nil
+	case "hourly":
+		return time.Hour, nil
+	case "daily":
+		return 24 * time.Hour, nil
+	}
+	return time.ParseDuration(interval)
+}
+
+func spoolFile(dir, name string) string {
+	if dir == "" {
+		return ""
+	}
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		panic(err)
+	}
+	return filepath.Join(dir, name)
+}
+
+// Connects to a collector given as udp://host:port, tcp://host:port or tls://host:port. TCP clients
+// spool to spool while the collector is down, if it is not empty
+func startForwarder(name, target, ca, spool string, newUdp, newTcp func() socketlogger.Client) socketlogger.Client {
+	u, err := url.Parse(target)
+	if err != nil {
+		panic(err)
+	}
+	port, err := strconv.Atoi(u.Port())
+	if err != nil {
+		panic(fmt.Errorf("%s needs a port: %v", name, err))
+	}
+
+	var client socketlogger.Client
+	switch u.Scheme {
+	case "udp":
+		client = newUdp()
+	case "tcp", "tls":
+		client = newTcp()
+		if u.Scheme == "tls" {
+			config, err := socketlogger.NewClientTLSConfig("", "", ca)
+			if err != nil {
+				panic(err)
+			}
+			client.SetTLSConfig(config)
+		}
+		if spool != "" {
+			if err := client.SetSpoolFile(spool); err != nil {
+				panic(err)
+			}
+		}
+	default:
+		panic(fmt.Errorf("unknown %s scheme %q, use udp, tcp or tls", name, u.Scheme))
+	}
+	if err := client.Connect(socketlogger.Connection{}, socketlogger.Connection{Addr: u.Hostname(), Port: port}); err != nil {
+		panic(err)
+	}
+	return client
+}
 
 func main() {
 	servers = make([]socketlogger.Server, 0)
@@ -54,50 +190,259 @@ func main() {
 	ltcp := flag.Int("log_tcp", 0, "Enable TCP server log messages on this port")
 	ldir := flag.String("log_dir", "logs", "Default directory to save log files to")
 	lmicro := flag.Bool("lsecs", false, "Turn off microseconds to log output")
-	lext := flag.String("log_ext", "log", "Log file extension")
+	lext := flag.String("log_ext", "", "Log file extension, log or jsonl with -log_format json by default")
+	lformat := flag.String("log_format", "text", "Log file format: text, or json for one JSON object per line")
+	lsize := flag.Int64("log_max_size", 0, "Rotate the log file once it reaches this many megabytes, 0 for no limit")
+	lrotate := flag.String("log_rotate", "", "Rotate the log file hourly, daily or every duration like 30m")
+	lgzip := flag.Bool("log_gzip", false, "Gzip rotated log files")
+	lkeep := flag.Int("log_keep", 0, "Rotated log files kept, 0 keeps all of them")
+	lage := flag.Duration("log_max_age", 0, "Remove rotated log files older than this, 0 keeps them forever")
+	ltime := flag.String("timestamps", "server", "Time written in front of log lines: server, client or both")
+	lskew := flag.Duration("skew", 5*time.Second, "Flag log lines whose client time is this far from the server time, 0 turns it off")
 
 	// CSV configs
 	cudp := flag.Int("csv_udp", 0, "Port to start UDP csv server")
 	ctcp := flag.Int("csv_tcp", 0, "Port to start TCP csv server")
 	cdir := flag.String("csv_dir", "csv", "Default directory to save csv files to")
+
+	// TLS configs, only used by the TCP and HTTP servers
+	tcert := flag.String("tls_cert", "", "Certificate file, enables TLS on the TCP and HTTP servers")
+	tkey := flag.String("tls_key", "", "Private key file for -tls_cert")
+	tca := flag.String("tls_ca", "", "CA bundle used to verify client certificates, enables mutual TLS")
+	sudp := flag.Int("syslog_udp", 0, "Accept syslog messages over UDP on this port, written to the log file")
+	stcp := flag.Int("syslog_tcp", 0, "Accept syslog messages over TCP on this port, written to the log file")
+	sforward := flag.String("syslog_forward", "", "Forward every log message to a syslog collector, e.g. udp://host:514 or tls://host:6514")
+	gudp := flag.Int("gelf_udp", 0, "Accept GELF messages over UDP on this port, written to the log file")
+	gtcp := flag.Int("gelf_tcp", 0, "Accept GELF messages over TCP on this port, written to the log file")
+	gforward := flag.String("gelf_forward", "", "Forward every log message to a GELF collector, e.g. udp://host:12201")
+	ulog := flag.String("upstream_log", "", "Relay every log message to another socketlogger server, e.g. tcp://central:40001")
+	ucsv := flag.String("upstream_csv", "", "Relay every csv row to another socketlogger server, e.g. tcp://central:50001")
+	uspool := flag.String("upstream_spool", "", "Directory to spool relayed messages to while the upstream server is down")
+	urelayed := flag.Bool("accept_relayed", false, "Keep the host of messages relayed by other socketlogger servers, only for a central server")
+	forwardca := flag.String("forward_ca", "", "CA bundle used to verify tls:// collectors, system roots if empty")
+	ltail := flag.Int("log_tail", 0, "Stream the log to browsers on this port, see the README for filters")
+	ltailHistory := flag.Int("log_tail_history", 10000, "Messages the log tail keeps to show new viewers")
+	lhttp := flag.Int("log_http", 0, "Accept log messages POSTed as JSON on this port")
+	chttp := flag.Int("csv_http", 0, "Accept csv messages POSTed as JSON on this port")
+
+	// Unix domain sockets, for clients on the same host
+	lunix := flag.String("log_unix", "", "Enable log messages on a Unix socket at this path")
+	cunix := flag.String("csv_unix", "", "Enable csv messages on a Unix socket at this path")
+	unixgram := flag.Bool("unixgram", false, "Use datagram Unix sockets instead of stream sockets")
+
+	reliable := flag.Bool("reliable", false, "Acknowledge UDP messages so reliable clients can retransmit lost ones")
+	frame := flag.String("framing", "stream", "How messages are delimited: stream, newline or length")
 	flag.Parse()
+	acceptRelayed = *urelayed
+
+	var err error
+	if framing, err = socketlogger.ParseFraming(*frame); err != nil {
+		panic(err)
+	}
+	if timestamps, err = socketlogger.ParseTimestamps(*ltime); err != nil {
+		panic(err)
+	}
+	skewWarning = *lskew
+	switch *lformat {
+	case "text":
+	case "json":
+		logJSON = true
+	default:
+		panic(fmt.Errorf("unknown -log_format %q, use text or json", *lformat))
+	}
+	if *lext == "" {
+		*lext = "log"
+		if logJSON {
+			*lext = "jsonl"
+		}
+	}
+
+	if *tcert != "" || *tkey != "" {
+		tlsConfig, err = socketlogger.NewServerTLSConfig(*tcert, *tkey, *tca)
+		if err != nil {
+			panic(err)
+		}
+	} else if *tca != "" {
+		panic("-tls_ca requires -tls_cert and -tls_key")
+	}
+
+	if *sforward != "" {
+		syslogForwarder = startForwarder("-syslog_forward", *sforward, *forwardca, "",
+			func() socketlogger.Client { return socketlogger.NewUdpSyslogClient() },
+			func() socketlogger.Client { return socketlogger.NewTcpSyslogClient() }).(socketlogger.SyslogClient)
+		defer syslogForwarder.Disconnect()
+	}
+	if *gforward != "" {
+		gelfForwarder = startForwarder("-gelf_forward", *gforward, *forwardca, "",
+			func() socketlogger.Client { return socketlogger.NewUdpGelfClient() },
+			func() socketlogger.Client { return socketlogger.NewTcpGelfClient() }).(socketlogger.GelfClient)
+		defer gelfForwarder.Disconnect()
+	}
+
+	if *ulog != "" {
+		upstreamLog = startForwarder("-upstream_log", *ulog, *forwardca, spoolFile(*uspool, "log.spool"),
+			func() socketlogger.Client { return socketlogger.NewUdpLoggerClient() },
+			func() socketlogger.Client { return socketlogger.NewTcpLoggerClient() }).(socketlogger.LoggerClient)
+		defer upstreamLog.Disconnect()
+	}
+	if *ucsv != "" {
+		upstreamCsv = startForwarder("-upstream_csv", *ucsv, *forwardca, spoolFile(*uspool, "csv.spool"),
+			func() socketlogger.Client { return socketlogger.NewUdpCsvClient() },
+			func() socketlogger.Client { return socketlogger.NewTcpCsvClient() }).(socketlogger.CsvClient)
+		defer upstreamCsv.Disconnect()
+	}
+
+	if *ltail != 0 {
+		liveTail = socketlogger.NewLiveTail()
+		if err := liveTail.SetHistory(*ltailHistory); err != nil {
+			panic(err)
+		}
+		if tlsConfig != nil {
+			liveTail.SetTLSConfig(tlsConfig)
+		}
+		if err := liveTail.Bind(socketlogger.Connection{Addr: *ip, Port: *ltail}); err != nil {
+			panic(err)
+		}
+		log.Println("Live tail listening on port:", liveTail.Addr().Port)
+		defer liveTail.Shutdown()
+	}
+
+	rotation := socketlogger.Rotation{
+		MaxSize:  *lsize << 20,
+		Compress: *lgzip,
+		MaxFiles: *lkeep,
+		MaxAge:   *lage,
+	}
+	if rotation.Interval, err = parseInterval(*lrotate); err != nil {
+		panic(err)
+	}
 
 	now := time.Now().Format("2006-01-02T15:04:05") + "." + *lext
+	if rotation.MaxSize > 0 || rotation.Interval > 0 {
+		now = "socketlogger." + *lext // Rotated files get the time in their names instead
+	}
 	logfile := filepath.Join(*ldir, now)
+	if rotation.MaxSize > 0 || rotation.Interval > 0 {
+		if rotatingLog, err = socketlogger.OpenRotatingFile(logfile, rotation); err != nil {
+			panic(err)
+		}
+		defer rotatingLog.Close()
+	}
 
 	if *ludp != 0 {
-		startLogger(socketlogger.NewUdpLoggerServer(), *ip, *ldir, now, *ludp, *lmicro)
+		server := socketlogger.NewUdpLoggerServer()
+		server.SetReliable(*reliable)
+		startLogger(server, socketlogger.Connection{Addr: *ip, Port: *ludp}, *ldir, now, *lmicro)
 	}
 
 	if *ltcp != 0 {
-		startLogger(socketlogger.NewTcpLoggerServer(), *ip, *ldir, now, *ltcp, *lmicro)
+		server := socketlogger.NewTcpLoggerServer()
+		setTLS(server)
+		startLogger(server, socketlogger.Connection{Addr: *ip, Port: *ltcp}, *ldir, now, *lmicro)
 	}
 
-	if *ltcp != 0 || *ludp != 0 {
+	if *lunix != "" {
+		var server socketlogger.LoggerServer
+		if *unixgram {
+			udg := socketlogger.NewUnixgramLoggerServer()
+			udg.SetReliable(*reliable)
+			server = udg
+		} else {
+			server = socketlogger.NewUnixLoggerServer()
+			setTLS(server)
+		}
+		startLogger(server, socketlogger.Connection{Path: *lunix}, *ldir, now, *lmicro)
+	}
+
+	if *lhttp != 0 {
+		server := socketlogger.NewHttpLoggerServer()
+		setTLS(server)
+		startLogger(server, socketlogger.Connection{Addr: *ip, Port: *lhttp}, *ldir, now, *lmicro)
+	}
+
+	if *sudp != 0 {
+		startLogger(socketlogger.NewUdpSyslogServer(), socketlogger.Connection{Addr: *ip, Port: *sudp}, *ldir, now, *lmicro)
+	}
+	if *stcp != 0 {
+		server := socketlogger.NewTcpSyslogServer()
+		setTLS(server)
+		startLogger(server, socketlogger.Connection{Addr: *ip, Port: *stcp}, *ldir, now, *lmicro)
+	}
+
+	if *gudp != 0 {
+		startLogger(socketlogger.NewUdpGelfServer(), socketlogger.Connection{Addr: *ip, Port: *gudp}, *ldir, now, *lmicro)
+	}
+	if *gtcp != 0 {
+		server := socketlogger.NewTcpGelfServer()
+		setTLS(server)
+		startLogger(server, socketlogger.Connection{Addr: *ip, Port: *gtcp}, *ldir, now, *lmicro)
+	}
+
+	if *ltcp != 0 || *ludp != 0 || *lunix != "" || *lhttp != 0 || *sudp != 0 || *stcp != 0 || *gudp != 0 || *gtcp != 0 {
 		defer func() {
 			log.Println("Log file written to:", logfile)
 		}()
 	}
 
 	if *cudp != 0 {
-		startCsv(socketlogger.NewUdpCsvServer(), *ip, *cdir, *cudp)
+		server := socketlogger.NewUdpCsvServer()
+		server.SetReliable(*reliable)
+		startCsv(server, socketlogger.Connection{Addr: *ip, Port: *cudp}, *cdir)
 	}
 	if *ctcp != 0 {
-		startCsv(socketlogger.NewTcpCsvServer(), *ip, *cdir, *ctcp)
+		server := socketlogger.NewTcpCsvServer()
+		setTLS(server)
+		startCsv(server, socketlogger.Connection{Addr: *ip, Port: *ctcp}, *cdir)
+	}
+	if *cunix != "" {
+		var server socketlogger.CsvServer
+		if *unixgram {
+			udg := socketlogger.NewUnixgramCsvServer()
+			udg.SetReliable(*reliable)
+			server = udg
+		} else {
+			server = socketlogger.NewUnixCsvServer()
+			setTLS(server)
+		}
+		startCsv(server, socketlogger.Connection{Path: *cunix}, *cdir)
+	}
+
+	if *chttp != 0 {
+		server := socketlogger.NewHttpCsvServer()
+		setTLS(server)
+		startCsv(server, socketlogger.Connection{Addr: *ip, Port: *chttp}, *cdir)
 	}
 
-	if *ctcp != 0 || *cudp != 0 {
+	if *ctcp != 0 || *cudp != 0 || *cunix != "" || *chttp != 0 {
 		defer func() {
 			log.Println("CSV files written to:", *cdir)
 		}()
 	}
 
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	reopened := make(chan bool)
+	go func() {
+		for range hup {
+			reopen()
+		}
+		close(reopened)
+	}()
+
 	quit := make(chan os.Signal, 2)
 	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
 	<-quit
 	fmt.Println()
+	signal.Stop(hup)
+	close(hup) // No more reopens once the servers start shutting down
+	<-reopened // A reopen still running finishes first
 
 	for _, server := range servers {
 		server.Shutdown()
+		for _, stats := range server.SenderStats() {
+			if stats.Lost > 0 {
+				log.Printf("Lost %d of %d messages from %s (session %s)\n", stats.Lost, stats.Lost+stats.Received, stats.Addr, stats.Session)
+			}
+		}
 	}
 }
@@ -0,0 +1,132 @@
+package socketlogger
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Anything bound like a server, such as a Server or a LiveTail
+type binder interface {
+	Bind(c Connection) error
+	Addr() Connection
+}
+
+// Binds server to a port the system picks on 127.0.0.1 and returns where clients connect to
+func bindLocal(t testing.TB, server binder) Connection {
+	t.Helper()
+	if err := server.Bind(Connection{Addr: "127.0.0.1"}); err != nil {
+		t.Fatal(err)
+	}
+	return server.Addr()
+}
+
+// Starts a TCP logger server that writes to a log file in a temporary directory. Returns the server,
+// the path of its log file and where clients connect to
+func startLoggerServer(t testing.TB) (LoggerServer, string, Connection) {
+	t.Helper()
+	dir := t.TempDir()
+	server := NewTcpLoggerServer()
+	server.SetLogFile(dir, "test.log")
+	return server, filepath.Join(dir, "test.log"), bindLocal(t, server)
+}
+
+// Connects a TCP logger client to remote
+func connectLogger(t testing.TB, remote Connection) LoggerClient {
+	t.Helper()
+	logger := NewTcpLoggerClient()
+	if err := logger.Connect(Connection{}, remote); err != nil {
+		t.Fatal(err)
+	}
+	return logger
+}
+
+// A TCP port nothing is listening on, for clients that connect before their server is up
+func freePort(t testing.TB) int {
+	t.Helper()
+	listener, err := net.Listen(tcpProtocol, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+// Fails t for every expected string that is missing from the file at path, and returns the file
+func assertLogContains(t testing.TB, path string, expected ...string) string {
+	t.Helper()
+	dat, _ := os.ReadFile(path)
+	for _, e := range expected {
+		if !strings.Contains(string(dat), e) {
+			t.Errorf("%q missing from %s:\n%s", e, filepath.Base(path), dat)
+		}
+	}
+	return string(dat)
+}
+
+// Waits for the file at path to contain expected while the server is still running, for tests that
+// need a message to have arrived before they go on
+func waitForLog(t testing.TB, path, expected string) {
+	t.Helper()
+	waitFor(t, fmt.Sprintf("%q in %s", expected, filepath.Base(path)), func() bool {
+		dat, _ := os.ReadFile(path)
+		return strings.Contains(string(dat), expected)
+	})
+}
+
+// The caller the logger server writes for a message logged offset lines after the call
+func callerAt(offset int) string {
+	_, file, line, _ := runtime.Caller(1)
+	return fmt.Sprintf("%s:%d", filepath.Base(file), line+offset)
+}
+
+// Polls done until it is true, failing t after 5 seconds
+func waitFor(t testing.TB, what string, done func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !done() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestBindPortZero(t *testing.T) {
+	for _, server := range []Server{NewTcpLoggerServer(), NewUdpLoggerServer(), NewHttpLoggerServer()} {
+		addr := bindLocal(t, server)
+		if addr.Addr != "127.0.0.1" || addr.Port == 0 {
+			t.Errorf("%T: expected the picked port, actual %+v", server, addr)
+		}
+		server.Shutdown()
+	}
+}
+
+// Everything a client sent before Shutdown is written, without waiting for the server to read it first
+func TestShutdownDrains(t *testing.T) {
+	for _, server := range []LoggerServer{NewTcpLoggerServer(), NewUdpLoggerServer()} {
+		dir := t.TempDir()
+		server.SetLogFile(dir, "drain.log")
+		remote := bindLocal(t, server)
+
+		var logger LoggerClient
+		if _, ok := server.(*UdpLoggerServer); ok {
+			logger = NewUdpLoggerClient()
+			logger.Connect(Connection{}, remote)
+		} else {
+			logger = connectLogger(t, remote)
+		}
+		for i := 0; i < 50; i++ {
+			logger.Log("message %d", i)
+		}
+		logger.Disconnect()
+		server.Shutdown()
+
+		assertLogContains(t, filepath.Join(dir, "drain.log"), "-- message 0"+string(reset), "-- message 49"+string(reset))
+	}
+}
@@ -7,12 +7,14 @@ import (
 	"path/filepath"
 	"runtime"
 	"strings"
+	"time"
 )
 
 type (
-	MessageType  string
-	messageLevel int
-	color        string
+	MessageType      string
+	messageLevel     int
+	color            string
+	ConnectionStatus int
 )
 
 const (
@@ -30,30 +32,113 @@ const (
 	cyan                color        = "\033[36m"
 	udpProtocol         string       = "udp"
 	tcpProtocol         string       = "tcp"
+	unixProtocol        string       = "unix"
+	unixgramProtocol    string       = "unixgram"
 	bufSize             int          = 16384
+	maxDatagramSize     int          = 65535
 	NativeFlags         int          = log.Lshortfile &^ (log.Ldate | log.Ltime)
 )
 
+const (
+	StatusDisconnected ConnectionStatus = 0
+	StatusConnecting   ConnectionStatus = 1 // Waiting for the server, messages are being buffered
+	StatusConnected    ConnectionStatus = 2
+
+	reconnectMin time.Duration = 100 * time.Millisecond
+	reconnectMax time.Duration = 30 * time.Second
+	backlogSize  int           = 10000
+	dialTimeout  time.Duration = 5 * time.Second
+	writeTimeout time.Duration = 10 * time.Second
+
+	ackTimeout    time.Duration = 200 * time.Millisecond // Reliable UDP, first retransmit
+	maxAckBackoff time.Duration = 5 * time.Second
+	ackLinger     time.Duration = 5 * time.Second // How long Disconnect waits for outstanding acks
+	ackWindow     int           = 1000            // Unacknowledged messages before the client stops sending
+)
+
+// Named after the client methods that send each level
+var levelNames = map[messageLevel]string{
+	MessageLevelLog:     "log",
+	MessageLevelWrn:     "wrn",
+	MessageLevelSuccess: "success",
+	MessageLevelErr:     "err",
+	MessageLevelDbg:     "dbg",
+}
+
+func parseLevel(name string) (messageLevel, bool) {
+	for lvl, n := range levelNames {
+		if n == name {
+			return lvl, true
+		}
+	}
+	return 0, false
+}
+
+func (s ConnectionStatus) String() string {
+	switch s {
+	case StatusConnecting:
+		return "connecting"
+	case StatusConnected:
+		return "connected"
+	}
+	return "disconnected"
+}
+
 type SocketMessage interface {
 	String() string
 	Type() MessageType
 }
 
+// Fields common to every message type that are filled in by the library rather than by the caller
+type envelope struct {
+	Identity string     `json:"identity,omitempty"` // Verified TLS client identity, set by the server
+	Host     string     `json:"host,omitempty"`     // Machine the message came from, set by relaying servers
+	Time     *time.Time `json:"time,omitempty"`     // When the client created the message, nil for older clients
+	Session  string     `json:"session,omitempty"`  // Random per connection ID, set by UDP clients
+	Seq      uint64     `json:"seq,omitempty"`      // Counts up from 1 within a session so the server can spot gaps
+	Buffered bool       `json:"buffered,omitempty"` // Held back or sent again by the client, so Time says nothing about its clock
+	Received time.Time  `json:"-"`                  // When the server got the message, zero for its own messages
+	Remote   string     `json:"-"`                  // Address the server got the message from
+}
+
+// Stamps msg with when and where the server got it
+func received(msg SocketMessage, from string) {
+	if e, ok := msg.(enveloped); ok {
+		env := e.env()
+		env.Received = time.Now()
+		env.Remote = from
+	}
+}
+
+func (e *envelope) env() *envelope {
+	return e
+}
+
+// Implemented by messages that carry an envelope
+type enveloped interface {
+	env() *envelope
+}
+
 type LogMessage struct {
 	Caller   string       `json:"caller"`
 	LogLevel messageLevel `json:"level"`
 	Message  string       `json:"message"`
+	Fields   Fields       `json:"fields,omitempty"`
+	envelope
 }
 
 type CsvMessage struct {
 	Caller   string        `json:"caller"`
 	Row      []interface{} `json:"row"`
 	Filename string        `json:"csv_filename"`
+	Header   bool          `json:"header,omitempty"` // Sent by NewCsvFile, written again at the top of reopened files
+	envelope
 }
 
 type Connection struct {
 	Addr string
 	Port int
+	Path string // Socket file, only used by the Unix servers and clients
 }
 
 func (l LogMessage) String() string {
@@ -75,7 +160,17 @@ func (l LogMessage) String() string {
 		l.Caller = ""
 		format = " |%s %s%s" // first %s is l.Caller, which is now blank
 	}
-	return str + fmt.Sprintf(format, l.Caller, strings.TrimSuffix(l.Message, "\n"), string(reset))
+	if l.Host != "" {
+		str += " | " + l.Host
+	}
+	if l.Identity != "" {
+		str += " | [" + l.Identity + "]"
+	}
+	msg := strings.TrimSuffix(l.Message, "\n")
+	if len(l.Fields) > 0 {
+		msg += " " + l.Fields.String()
+	}
+	return str + fmt.Sprintf(format, l.Caller, msg, string(reset))
 }
 
 func (LogMessage) Type() MessageType {
@@ -90,10 +185,12 @@ func newLogMessageCaller(lvl messageLevel, file string, line int, ok bool, forma
 	if file == "embedded" {
 		caller = file
 	}
+	now := time.Now()
 	return &LogMessage{
 		LogLevel: lvl,
 		Caller:   caller,
 		Message:  fmt.Sprintf(format, args...),
+		envelope: envelope{Time: &now},
 	}
 }
 
@@ -130,10 +227,12 @@ func newCsvMessage(fname string, row []interface{}) SocketMessage {
 		caller = fmt.Sprintf("%s:%d", paths[len(paths)-1], line)
 	}
 
+	now := time.Now()
 	return &CsvMessage{
 		Caller:   caller,
 		Filename: fname,
 		Row:      row,
+		envelope: envelope{Time: &now},
 	}
 }
 
@@ -0,0 +1,304 @@
+package socketlogger
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Sink is somewhere a logger server writes messages, like a file, a database or a message bus. Add one
+// with LoggerServer.AddSink. Sinks that also have a Reopen() error method are reopened by LoggerServer.Reopen
+type Sink interface {
+	Write(msg SocketMessage) error
+	// Called once by Shutdown, after the last message has been written
+	Close() error
+}
+
+// SinkFunc is a Sink that calls a function for every message. Close does nothing
+type SinkFunc func(msg SocketMessage) error
+
+func (f SinkFunc) Write(msg SocketMessage) error {
+	return f(msg)
+}
+
+func (f SinkFunc) Close() error {
+	return nil
+}
+
+// A sink added to a server and the levels it gets, nil for all of them
+type sinkEntry struct {
+	sink    Sink
+	levels  map[messageLevel]bool
+	lastErr string // Only reported again once it changes
+}
+
+func (e *sinkEntry) accepts(msg SocketMessage) bool {
+	if e.levels == nil {
+		return true
+	}
+	inst, ok := msg.(*LogMessage)
+	return !ok || e.levels[inst.LogLevel]
+}
+
+type reopener interface {
+	Reopen() error
+}
+
+// Publishes to the tail, forwarders and relay, which their owners shut down themselves
+func publishTo(o output) Sink {
+	return SinkFunc(func(msg SocketMessage) error {
+		if inst, ok := msg.(*LogMessage); ok {
+			o.publish(inst)
+		}
+		return nil
+	})
+}
+
+// TextSink writes messages as lines of text, the way the logger server always has
+type TextSink struct {
+	out         *log.Logger
+	file        *os.File  // Closed by Close
+	tee         io.Writer // Also written besides file, nil for only the file
+	color       bool
+	timestamps  Timestamps
+	skewWarning time.Duration // 0 is the default, negative is off
+}
+
+// NewTextSink writes colored lines to w, starting with the time in log.LstdFlags
+func NewTextSink(w io.Writer) *TextSink {
+	return &TextSink{
+		out:   log.New(w, "", log.LstdFlags),
+		color: true,
+	}
+}
+
+// NewConsoleSink writes colored lines to os.Stdout
+func NewConsoleSink() *TextSink {
+	return NewTextSink(console{})
+}
+
+// NewFileSink appends plain lines, without colors, to the file at path. Its directory is created if needed
+func NewFileSink(path string) (*TextSink, error) {
+	file, err := openLogFile(path)
+	if err != nil {
+		return nil, err
+	}
+	t := NewTextSink(file)
+	t.useFile(file, nil)
+	t.color = false
+	return t, nil
+}
+
+func openLogFile(path string) (*os.File, error) {
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return nil, err
+	}
+	return os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o666)
+}
+
+// Writes to os.Stdout as it is when each line is written
+type console struct{}
+
+func (console) Write(p []byte) (int, error) {
+	return os.Stdout.Write(p)
+}
+
+// SetOutput writes lines to w from now on, closing the file the sink was opened with
+func (t *TextSink) SetOutput(w io.Writer) {
+	t.closeFile()
+	t.tee = nil
+	t.out.SetOutput(w)
+}
+
+// Writes lines to file, and to tee too if it isn't nil
+func (t *TextSink) useFile(file *os.File, tee io.Writer) {
+	t.closeFile()
+	t.file, t.tee = file, tee
+	if tee != nil {
+		t.out.SetOutput(io.MultiWriter(tee, file))
+	} else {
+		t.out.SetOutput(file)
+	}
+}
+
+// Reopen opens the file the sink writes to again by its path, e.g. after logrotate moved it. Lines
+// go to the old file until the new one is open
+func (t *TextSink) Reopen() error {
+	if t.file == nil {
+		return nil
+	}
+	file, err := openLogFile(t.file.Name())
+	if err != nil {
+		return err
+	}
+	t.useFile(file, t.tee)
+	return nil
+}
+
+// SetColor keeps or strips the ANSI colors of every line
+func (t *TextSink) SetColor(on bool) {
+	t.color = on
+}
+
+// SetTimeFlags sets the log package flags used for the time in front of every line
+func (t *TextSink) SetTimeFlags(flags int) {
+	t.out.SetFlags(flags)
+}
+
+// SetTimestamps picks whether lines start with the time the server wrote them, the time the client
+// created them, or both. Client times are only known for messages from clients that send them
+func (t *TextSink) SetTimestamps(timestamps Timestamps) error {
+	if _, ok := timestampNames[timestamps]; !ok {
+		return fmt.Errorf("unknown timestamps %v", timestamps)
+	}
+	t.timestamps = timestamps
+	return nil
+}
+
+// SetClockSkewWarning flags lines whose client time is more than threshold away from the server time,
+// 5 seconds by default. 0 turns the warning off
+func (t *TextSink) SetClockSkewWarning(threshold time.Duration) error {
+	if threshold < 0 {
+		return fmt.Errorf("clock skew threshold %v is negative", threshold)
+	} else if threshold == 0 {
+		threshold = -1
+	}
+	t.skewWarning = threshold
+	return nil
+}
+
+func (t *TextSink) Write(msg SocketMessage) error {
+	out := t.out
+	inst, ok := msg.(*LogMessage)
+	if !ok || inst.Time == nil {
+		return out.Output(2, t.colored(msg.String()))
+	}
+
+	threshold := t.skewWarning
+	if threshold == 0 {
+		threshold = defaultSkewWarning
+	}
+	if inst.Buffered {
+		threshold = -1 // Late because it waited, not because of the clock
+	}
+	line := t.colored(inst.String() + skewNote(inst.Time, time.Now(), threshold))
+	clientTime := formatTime(*inst.Time, out.Flags())
+	switch {
+	case t.timestamps == TimestampClient:
+		_, err := fmt.Fprint(out.Writer(), out.Prefix()+clientTime+line+"\n") // Skips the server time the logger adds
+		return err
+	case t.timestamps == TimestampBoth && clientTime != "":
+		return out.Output(2, "| client "+strings.TrimSuffix(clientTime, " ")+line)
+	default:
+		return out.Output(2, line)
+	}
+}
+
+func (t *TextSink) colored(line string) string {
+	if t.color {
+		return line
+	}
+	return ansiColors.ReplaceAllString(line, "")
+}
+
+// Close closes the file the sink was opened with, if any
+func (t *TextSink) Close() error {
+	return t.closeFile()
+}
+
+func (t *TextSink) closeFile() error {
+	if t.file == nil {
+		return nil
+	}
+	err := t.file.Close()
+	t.file = nil
+	return err
+}
+
+// JSONSink writes every message as one JSON object per line, for jq and log shippers. Each line has the
+// time the server got the message, the level name, caller, message, the address it came from and the fields
+type JSONSink struct {
+	enc  *json.Encoder
+	file *os.File // Closed by Close
+}
+
+// A line of a JSONSink
+type jsonRecord struct {
+	Time       time.Time  `json:"time"` // When the server got the message
+	Level      string     `json:"level,omitempty"`
+	Caller     string     `json:"caller,omitempty"`
+	Message    string     `json:"message"`
+	Remote     string     `json:"remote,omitempty"`
+	Fields     Fields     `json:"fields,omitempty"`
+	Identity   string     `json:"identity,omitempty"`
+	Host       string     `json:"host,omitempty"`
+	ClientTime *time.Time `json:"client_time,omitempty"`
+}
+
+// NewJSONSink writes a line to w for every message
+func NewJSONSink(w io.Writer) *JSONSink {
+	return &JSONSink{
+		enc: json.NewEncoder(w),
+	}
+}
+
+// NewJSONFileSink appends a line to the file at path for every message. Its directory is created if needed
+func NewJSONFileSink(path string) (*JSONSink, error) {
+	file, err := openLogFile(path)
+	if err != nil {
+		return nil, err
+	}
+	j := NewJSONSink(file)
+	j.file = file
+	return j, nil
+}
+
+// Reopen opens the file the sink writes to again by its path, e.g. after logrotate moved it
+func (j *JSONSink) Reopen() error {
+	if j.file == nil {
+		return nil
+	}
+	file, err := openLogFile(j.file.Name())
+	if err != nil {
+		return err
+	}
+	j.file.Close()
+	j.file = file
+	j.enc = json.NewEncoder(file)
+	return nil
+}
+
+func (j *JSONSink) Write(msg SocketMessage) error {
+	record := jsonRecord{Time: time.Now()}
+	if inst, ok := msg.(*LogMessage); ok {
+		record.Level = levelNames[inst.LogLevel]
+		if !inst.Received.IsZero() {
+			record.Time = inst.Received
+		}
+		record.Caller = inst.Caller
+		record.Message = inst.Message
+		record.Remote = inst.Remote
+		record.Fields = inst.Fields
+		record.Identity = inst.Identity
+		record.Host = inst.Host
+		record.ClientTime = inst.Time
+	} else {
+		record.Message = ansiColors.ReplaceAllString(msg.String(), "")
+	}
+	return j.enc.Encode(record)
+}
+
+// Close closes the file the sink was opened with, if any
+func (j *JSONSink) Close() error {
+	if j.file == nil {
+		return nil
+	}
+	err := j.file.Close()
+	j.file = nil
+	return err
+}
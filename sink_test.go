@@ -0,0 +1,183 @@
+package socketlogger
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSinks(t *testing.T) {
+	dir := t.TempDir()
+	var console bytes.Buffer
+	server := NewTcpLoggerServer()
+	server.SetOutput(&console)
+
+	plain, err := NewFileSink(filepath.Join(dir, "plain", "all.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.AddSink(plain)
+	warnings, err := NewJSONFileSink(filepath.Join(dir, "errors.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.AddSink(warnings, MessageLevelErr, MessageLevelWrn)
+
+	var mu sync.Mutex
+	var seen []string
+	server.AddSink(SinkFunc(func(msg SocketMessage) error {
+		mu.Lock()
+		defer mu.Unlock()
+		inst := msg.(*LogMessage)
+		seen = append(seen, levelNames[inst.LogLevel]+" "+inst.Message)
+		return nil
+	}), MessageLevelSuccess)
+	if err := server.AddSink(plain, 42); err == nil {
+		t.Error("Expected an error for an unknown level")
+	}
+
+	logger := connectLogger(t, bindLocal(t, server))
+	logger.Log("started")
+	caller := callerAt(1)
+	logger.Errw("disk full", "free", 0)
+	logger.Success("done")
+	logger.Disconnect()
+	server.Shutdown()
+
+	dat := assertLogContains(t, filepath.Join(dir, "plain", "all.log"), "-- started\n", "-- disk full free=0\n", "-- done\n")
+	if strings.Contains(dat, "\x1b[") {
+		t.Errorf("Expected no colors in the plain file:\n%q", dat)
+	}
+	if !strings.Contains(console.String(), string(red)) {
+		t.Errorf("Expected colors on the console:\n%q", console.String())
+	}
+
+	jsonl, _ := os.ReadFile(filepath.Join(dir, "errors.jsonl"))
+	lines := strings.Split(strings.TrimSpace(string(jsonl)), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("Expected only the error in the JSON file:\n%s", jsonl)
+	}
+	var record map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[0]), &record); err != nil {
+		t.Fatal(err)
+	}
+	if record["level"] != "err" || record["message"] != "disk full" || record["caller"] != caller ||
+		record["fields"].(map[string]interface{})["free"] != float64(0) || record["time"] == nil {
+		t.Errorf("Unexpected record %v", record)
+	}
+
+	// The server's own success lines come first
+	if len(seen) == 0 || seen[len(seen)-1] != "success done" {
+		t.Errorf("Expected the success last in the callback, actual %q", seen)
+	}
+	for _, line := range seen {
+		if !strings.HasPrefix(line, "success ") {
+			t.Errorf("Expected only successes in the callback, actual %q", seen)
+		}
+	}
+}
+
+func TestFailingSink(t *testing.T) {
+	var console bytes.Buffer
+	server := NewTcpLoggerServer()
+	server.SetOutput(&console)
+	server.AddSink(SinkFunc(func(msg SocketMessage) error {
+		return errors.New("bus unreachable")
+	}))
+	logger := connectLogger(t, bindLocal(t, server))
+	for i := 0; i < 3; i++ {
+		logger.Log("message %d", i)
+	}
+	logger.Disconnect()
+	server.Shutdown()
+
+	if n := strings.Count(console.String(), "failed: bus unreachable"); n != 1 {
+		t.Errorf("Expected the failure once, actual %d times:\n%s", n, console.String())
+	}
+	if !strings.Contains(console.String(), "-- message 2") {
+		t.Errorf("Expected the messages to still be written:\n%s", console.String())
+	}
+}
+
+// Fails lines containing "unwritable"
+type pickyWriter struct {
+	bytes.Buffer
+}
+
+func (p *pickyWriter) Write(b []byte) (int, error) {
+	if bytes.Contains(b, []byte("unwritable")) {
+		return 0, errors.New("disk full")
+	}
+	return p.Buffer.Write(b)
+}
+
+func TestFailingTextOutput(t *testing.T) {
+	var out pickyWriter
+	server := NewTcpLoggerServer()
+	server.SetOutput(&out)
+	logger := connectLogger(t, bindLocal(t, server))
+	logger.Log("unwritable")
+	logger.Log("written")
+	logger.Disconnect()
+	server.Shutdown()
+
+	if !strings.Contains(out.String(), "Sink *socketlogger.TextSink failed: disk full") || !strings.Contains(out.String(), "-- written") {
+		t.Errorf("Expected the failure to be reported:\n%s", out.String())
+	}
+}
+
+func TestJSONLines(t *testing.T) {
+	var tcpLines, httpLines bytes.Buffer
+	tcp := NewTcpLoggerServer()
+	tcp.SetOutput(io.Discard)
+	tcp.AddSink(NewJSONSink(&tcpLines), MessageLevelWrn)
+	logger := connectLogger(t, bindLocal(t, tcp))
+	h := NewHttpLoggerServer()
+	h.SetOutput(io.Discard)
+	h.AddSink(NewJSONSink(&httpLines), MessageLevelWrn)
+	remote := bindLocal(t, h)
+
+	caller := callerAt(1)
+	logger.Wrnw("frame dropped", "queue", 31)
+	logger.Disconnect()
+	resp, err := http.Post(fmt.Sprintf("http://127.0.0.1:%d/", remote.Port), "application/json", strings.NewReader(`{"caller":"curl","level":1,"message":"posted"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	tcp.Shutdown()
+	h.Shutdown()
+
+	for _, test := range []struct {
+		lines          *bytes.Buffer
+		caller, fields string
+	}{
+		{&tcpLines, caller, `{"queue":31}`},
+		{&httpLines, "curl", ""},
+	} {
+		var record struct {
+			Time    time.Time
+			Level   string
+			Caller  string
+			Message string
+			Remote  string
+			Fields  json.RawMessage
+		}
+		if err := json.Unmarshal(test.lines.Bytes(), &record); err != nil {
+			t.Fatalf("%v: %s", err, test.lines)
+		}
+		if record.Level != "wrn" || record.Caller != test.caller || string(record.Fields) != test.fields ||
+			!strings.HasPrefix(record.Remote, "127.0.0.1:") || time.Since(record.Time) > time.Second {
+			t.Errorf("Unexpected record %+v", record)
+		}
+	}
+}
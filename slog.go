@@ -0,0 +1,138 @@
+//go:build go1.21
+
+package socketlogger
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"runtime"
+)
+
+// Between info and warn, so slog callers can send Success messages
+const SlogLevelSuccess slog.Level = slog.LevelInfo + 2
+
+// SlogHandler is a slog.Handler that sends every record through a LoggerClient. Attributes become
+// structured fields, with the names of the groups they are in joined by dots, e.g. req.method. The
+// client's context extractor adds fields from the context passed to the *Context methods
+type SlogHandler struct {
+	client LoggerClient
+	level  slog.Leveler
+	fields Fields // From WithAttrs
+	prefix string // Groups from WithGroup, "req.", applied to attributes added after it
+}
+
+// NewSlogHandler sends records at or above opts.Level (info when nil) to client. Only opts.Level is
+// used, the caller is always sent
+func NewSlogHandler(client LoggerClient, opts *slog.HandlerOptions) *SlogHandler {
+	h := &SlogHandler{
+		client: client,
+		level:  slog.LevelInfo,
+	}
+	if opts != nil && opts.Level != nil {
+		h.level = opts.Level
+	}
+	return h
+}
+
+func (h *SlogHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h *SlogHandler) Handle(ctx context.Context, r slog.Record) error {
+	msg := &LogMessage{
+		LogLevel: slogLevel(r.Level),
+		Message:  r.Message,
+	}
+	if r.PC != 0 {
+		frame, _ := runtime.CallersFrames([]uintptr{r.PC}).Next()
+		msg.Caller = fmt.Sprintf("%s:%d", filepath.Base(frame.File), frame.Line)
+	}
+	if !r.Time.IsZero() {
+		sent := r.Time
+		msg.Time = &sent
+	}
+
+	if fromContext := h.client.contextFields(ctx); len(h.fields) > 0 || r.NumAttrs() > 0 || len(fromContext) > 0 {
+		msg.Fields = make(Fields, len(fromContext)+len(h.fields)+r.NumAttrs())
+		for key, value := range fromContext {
+			msg.Fields[key] = value
+		}
+		for key, value := range h.fields {
+			msg.Fields[key] = value
+		}
+		r.Attrs(func(attr slog.Attr) bool {
+			addAttr(msg.Fields, h.prefix, attr)
+			return true
+		})
+	}
+	h.client.Send(msg)
+	return nil
+}
+
+func (h *SlogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	if len(attrs) == 0 {
+		return h
+	}
+	child := *h
+	child.fields = make(Fields, len(h.fields)+len(attrs))
+	for key, value := range h.fields {
+		child.fields[key] = value
+	}
+	for _, attr := range attrs {
+		addAttr(child.fields, h.prefix, attr)
+	}
+	return &child
+}
+
+func (h *SlogHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	child := *h
+	child.prefix = h.prefix + name + "."
+	return &child
+}
+
+// Follows the slog rules: empty keys are dropped, groups are flattened and empty groups dropped
+func addAttr(fields Fields, prefix string, attr slog.Attr) {
+	attr.Value = attr.Value.Resolve()
+	if attr.Equal(slog.Attr{}) {
+		return
+	}
+
+	switch attr.Value.Kind() {
+	case slog.KindGroup:
+		group := attr.Value.Group()
+		if attr.Key != "" {
+			prefix += attr.Key + "."
+		}
+		for _, member := range group {
+			addAttr(fields, prefix, member)
+		}
+	case slog.KindAny:
+		if err, ok := attr.Value.Any().(error); ok {
+			fields[prefix+attr.Key] = err.Error() // Most errors marshal to {}
+		} else {
+			fields[prefix+attr.Key] = attr.Value.Any()
+		}
+	default:
+		fields[prefix+attr.Key] = attr.Value.Any()
+	}
+}
+
+// Debug and below is Dbg, info is Log, SlogLevelSuccess is Success, warn is Wrn, error and above is Err
+func slogLevel(level slog.Level) messageLevel {
+	switch {
+	case level < slog.LevelInfo:
+		return MessageLevelDbg
+	case level < SlogLevelSuccess:
+		return MessageLevelLog
+	case level < slog.LevelWarn:
+		return MessageLevelSuccess
+	case level < slog.LevelError:
+		return MessageLevelWrn
+	}
+	return MessageLevelErr
+}
@@ -0,0 +1,56 @@
+//go:build go1.21
+
+package socketlogger
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestSlogLevel(t *testing.T) {
+	for level, expected := range map[slog.Level]messageLevel{
+		slog.LevelDebug - 4: MessageLevelDbg,
+		slog.LevelDebug:     MessageLevelDbg,
+		slog.LevelInfo:      MessageLevelLog,
+		SlogLevelSuccess:    MessageLevelSuccess,
+		slog.LevelWarn:      MessageLevelWrn,
+		slog.LevelError:     MessageLevelErr,
+		slog.LevelError + 4: MessageLevelErr,
+	} {
+		if actual := slogLevel(level); actual != expected {
+			t.Errorf("%v: expected %v, actual %v", level, expected, actual)
+		}
+	}
+}
+
+func TestSlogHandler(t *testing.T) {
+	server, path, remote := startLoggerServer(t)
+	client := connectLogger(t, remote)
+	logger := slog.New(NewSlogHandler(client, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	_, _, line, _ := runtime.Caller(0)
+	logger.Debug("debugging", "attempt", 3)
+	logger.With("service", "video").WithGroup("req").Warn("slow request",
+		slog.String("method", "GET"), slog.Group("timing", "ms", 812), slog.Group("empty"), "", nil)
+	logger.Error("upload failed", "err", errors.New("connection reset"))
+	logger.Log(context.Background(), SlogLevelSuccess, "uploaded")
+	slog.New(NewSlogHandler(client, nil)).Debug("filtered out")
+	slog.New(NewSlogHandler(client.With("rig", 7), nil)).Info("from a child")
+	client.Disconnect()
+	server.Shutdown()
+
+	dat := assertLogContains(t, path,
+		string(cyan)+fmt.Sprintf(" | slog_test.go:%d -- debugging attempt=3", line+1),
+		string(yellow)+fmt.Sprintf(" | slog_test.go:%d -- slow request req.method=GET req.timing.ms=812 service=video", line+2),
+		string(red)+fmt.Sprintf(` | slog_test.go:%d -- upload failed err="connection reset"`, line+4),
+		string(green)+fmt.Sprintf(" | slog_test.go:%d -- uploaded", line+5),
+		fmt.Sprintf(" | slog_test.go:%d -- from a child rig=7", line+7),
+	)
+	if strings.Contains(dat, "filtered out") {
+		t.Errorf("Debug records are below the default level:\n%s", dat)
+	}
+}
@@ -0,0 +1,136 @@
+package socketlogger
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Append-only file of marshalled messages, one per line, that could not be sent to the server.
+// Anything left in the file is sent the next time a client using the same path connects
+type spool struct {
+	path string
+	file *os.File
+	size int64 // Bytes waiting to be drained
+}
+
+func openSpool(path string) (*spool, error) {
+	if dir := filepath.Dir(path); !fileDirExists(dir, "") {
+		if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+			return nil, err
+		}
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_RDWR, 0o666)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &spool{
+		path: path,
+		file: file,
+		size: info.Size(),
+	}, nil
+}
+
+func (s *spool) empty() bool {
+	return s.size == 0
+}
+
+// Synced to disk before returning so the message survives the machine going down
+func (s *spool) append(msg []byte) error {
+	n, err := s.file.Write(append(msg, '\n'))
+	s.size += int64(n)
+	if err != nil {
+		return err
+	}
+	return s.file.Sync()
+}
+
+// Sends every spooled message in order. On failure the messages that were sent are removed
+// from the file and the rest are kept for the next attempt
+func (s *spool) drain(send func([]byte) error) error {
+	if s.empty() {
+		return nil
+	}
+
+	file, err := os.Open(s.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var offset int64
+	reader := bufio.NewReaderSize(file, bufSize)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			break // A line without a newline is a write that was cut off, it can't be trusted
+		} else if err != nil {
+			return s.compact(offset, err)
+		}
+
+		if msg := bytes.TrimSuffix(line, []byte("\n")); len(msg) > 0 {
+			if err := send(msg); err != nil {
+				return s.compact(offset, err)
+			}
+		}
+		offset += int64(len(line))
+	}
+
+	if err := s.file.Truncate(0); err != nil {
+		return err
+	}
+	s.size = 0
+	return nil
+}
+
+// Rewrites the spool without the first offset bytes, returns cause. The spool keeps appending to the
+// file it has until the rewritten one has replaced it
+func (s *spool) compact(offset int64, cause error) error {
+	if offset == 0 {
+		return cause
+	}
+
+	src, err := os.Open(s.path)
+	if err != nil {
+		return cause
+	}
+	defer src.Close()
+	if _, err := src.Seek(offset, io.SeekStart); err != nil {
+		return cause
+	}
+
+	tmp := s.path + ".tmp"
+	dst, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_APPEND|os.O_RDWR, 0o666)
+	if err != nil {
+		return cause
+	}
+	size, err := io.Copy(dst, src)
+	if err == nil {
+		err = dst.Sync()
+	}
+	if err == nil {
+		err = os.Rename(tmp, s.path) // dst is the spool from here on
+	}
+	if err != nil {
+		dst.Close()
+		os.Remove(tmp)
+		return cause
+	}
+
+	s.file.Close()
+	s.file = dst
+	s.size = size
+	return cause
+}
+
+func (s *spool) close() error {
+	return s.file.Close()
+}
@@ -0,0 +1,330 @@
+package socketlogger
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	syslogSDID     string = "socketlogger@32473" // Structured data ID the caller is sent under
+	syslogFacility int    = 1                    // user-level messages
+)
+
+// Syslog severities, RFC 5424 section 6.2.1
+var syslogLevels = [8]messageLevel{
+	MessageLevelErr, // Emergency
+	MessageLevelErr, // Alert
+	MessageLevelErr, // Critical
+	MessageLevelErr, // Error
+	MessageLevelWrn, // Warning
+	MessageLevelLog, // Notice
+	MessageLevelLog, // Informational
+	MessageLevelDbg, // Debug
+}
+
+// The other way, used when forwarding
+var syslogSeverities = map[messageLevel]int{
+	MessageLevelErr:     3,
+	MessageLevelWrn:     4,
+	MessageLevelSuccess: 5,
+	MessageLevelLog:     6,
+	MessageLevelDbg:     7,
+}
+
+var syslogMonths = []string{"Jan", "Feb", "Mar", "Apr", "May", "Jun", "Jul", "Aug", "Sep", "Oct", "Nov", "Dec"}
+
+// Parses syslog messages instead of JSON. Embedded next to a transport server and loggerserver
+type syslogserver struct{}
+
+// SetFraming is not used, syslog has its own framing
+func (s *syslogserver) SetFraming(framing Framing) error {
+	return fmt.Errorf("framing is not supported by the syslog servers")
+}
+
+func (s *syslogserver) decode(frame []byte) (SocketMessage, error) {
+	return parseSyslog(frame)
+}
+
+// Accepts RFC 5424 and RFC 3164 (BSD) messages. The severity becomes the level and the hostname and
+// app name become the caller and the facility is kept in the facility field. RFC 5424 timestamps are kept as the client time, RFC 3164 ones have no
+// year or zone so they are dropped
+func parseSyslog(frame []byte) (*LogMessage, error) {
+	msg := string(bytes.TrimLeft(bytes.TrimRight(frame, "\r\n\x00"), " \t\r\n"))
+	if strings.TrimSpace(msg) == "" {
+		return nil, fmt.Errorf("empty syslog message")
+	}
+
+	// RFC 3164 4.3.3, a message without a priority is user.notice
+	pri := 13
+	if strings.HasPrefix(msg, "<") {
+		end := strings.IndexByte(msg, '>')
+		if end < 2 || end > 4 {
+			return nil, fmt.Errorf("bad syslog priority")
+		}
+		var err error
+		if pri, err = strconv.Atoi(msg[1:end]); err != nil || pri < 0 || pri > 191 {
+			return nil, fmt.Errorf("bad syslog priority %q", msg[1:end])
+		}
+		msg = msg[end+1:]
+	}
+
+	parsed := &LogMessage{LogLevel: syslogLevels[pri%8], Fields: Fields{"facility": pri / 8}}
+	if strings.HasPrefix(msg, "1 ") {
+		return parsed, parse5424(parsed, msg[2:])
+	}
+	parse3164(parsed, msg)
+	return parsed, nil
+}
+
+// TIMESTAMP HOSTNAME APP-NAME PROCID MSGID STRUCTURED-DATA [MSG], "-" for anything missing
+func parse5424(parsed *LogMessage, msg string) error {
+	fields := strings.SplitN(msg, " ", 6)
+	if len(fields) < 6 {
+		return fmt.Errorf("truncated RFC 5424 message")
+	}
+	host, app, procid := nilValue(fields[1]), nilValue(fields[2]), nilValue(fields[3])
+	if sent, err := time.Parse(time.RFC3339Nano, fields[0]); err == nil {
+		parsed.Time = &sent
+	}
+
+	rest, data := fields[5], ""
+	switch {
+	case rest == "-" || strings.HasPrefix(rest, "- "):
+		rest = strings.TrimPrefix(rest[1:], " ")
+	case strings.HasPrefix(rest, "["):
+		end := structuredDataEnd(rest)
+		if end < 0 {
+			return fmt.Errorf("unterminated structured data")
+		}
+		data, rest = rest[:end], strings.TrimPrefix(rest[end:], " ")
+	default:
+		return fmt.Errorf("bad structured data")
+	}
+	rest = strings.TrimPrefix(rest, "\ufeff") // MSG may start with a UTF-8 BOM
+
+	parsed.Caller = syslogCaller(host, app, procid)
+	parsed.Message = strings.TrimSpace(data + " " + rest) // Structured data is kept in front of the message
+	return nil
+}
+
+// Returns the index just past the last SD-ELEMENT, -1 if one is not closed. Values are quoted and
+// may contain escaped quotes and brackets
+func structuredDataEnd(s string) int {
+	i := 0
+	for i < len(s) && s[i] == '[' {
+		quoted := false
+		for i++; i < len(s); i++ {
+			if s[i] == '\\' && quoted {
+				i++
+			} else if s[i] == '"' {
+				quoted = !quoted
+			} else if s[i] == ']' && !quoted {
+				break
+			}
+		}
+		if i >= len(s) {
+			return -1
+		}
+		i++
+	}
+	return i
+}
+
+// Mmm dd hh:mm:ss HOSTNAME TAG: MSG. Senders leave out whatever they like, so anything that
+// doesn't look like the expected field is treated as the start of the message
+func parse3164(parsed *LogMessage, msg string) {
+	if len(msg) >= 16 && isMonth(msg[:3]) && msg[3] == ' ' && msg[15] == ' ' && msg[9] == ':' && msg[12] == ':' {
+		msg = msg[16:]
+	}
+
+	host := ""
+	if space := strings.IndexByte(msg, ' '); space > 0 && !isTag(msg[:space]) {
+		if next := msg[space+1:]; isTag(firstWord(next)) {
+			host, msg = msg[:space], next
+		}
+	}
+
+	app, procid := "", ""
+	if tag := firstWord(msg); isTag(tag) {
+		msg = strings.TrimPrefix(msg[len(tag):], " ")
+		app = strings.TrimSuffix(tag, ":")
+		if open := strings.IndexByte(app, '['); open > 0 && strings.HasSuffix(app, "]") {
+			app, procid = app[:open], app[open+1:len(app)-1]
+		}
+	}
+
+	parsed.Caller = syslogCaller(host, app, procid)
+	parsed.Message = msg
+}
+
+// host/app[procid], leaving out whatever is missing
+func syslogCaller(host, app, procid string) string {
+	caller := app
+	if procid != "" && app != "" {
+		caller += "[" + procid + "]"
+	}
+	if host != "" && caller != "" {
+		return host + "/" + caller
+	}
+	return host + caller
+}
+
+func nilValue(field string) string {
+	if field == "-" {
+		return ""
+	}
+	return field
+}
+
+func firstWord(s string) string {
+	if space := strings.IndexByte(s, ' '); space >= 0 {
+		return s[:space]
+	}
+	return s
+}
+
+// A TAG is the app name, optionally with [pid], followed by a colon
+func isTag(word string) bool {
+	return len(word) > 1 && strings.HasSuffix(word, ":") && !strings.ContainsAny(word[:len(word)-1], ":/")
+}
+
+func isMonth(s string) bool {
+	for _, month := range syslogMonths {
+		if s == month {
+			return true
+		}
+	}
+	return false
+}
+
+type SyslogClient interface {
+	SetAppName(name string)
+	SetHostname(name string)
+	SetFacility(facility int) error
+	Client
+
+	publish(msg *LogMessage)
+}
+
+// Sends messages to a syslog collector as RFC 5424. Embedded next to a transport client
+type syslogclient struct {
+	forwardclient
+	appName  string
+	facility int
+}
+
+func (s *syslogclient) initSyslogClient() {
+	s.initForwardClient()
+	s.appName = "socketlogger"
+	s.facility = syslogFacility
+}
+
+// SetAppName sets the APP-NAME field, "socketlogger" by default. Must be called before Connect
+func (s *syslogclient) SetAppName(name string) {
+	s.appName = name
+}
+
+// SetFacility sets the facility every message is sent with, 1 (user) by default. Must be called before Connect
+func (s *syslogclient) SetFacility(facility int) error {
+	if facility < 0 || facility > 23 {
+		return fmt.Errorf("syslog facility %d is not between 0 and 23", facility)
+	}
+	s.facility = facility
+	return nil
+}
+
+// <PRI>1 TIMESTAMP HOSTNAME APP-NAME - - [socketlogger@32473 caller="..." field="..."] MSG. Line breaks in the
+// message are escaped as #012 like rsyslog does, so each message stays on one line in the spool
+func (s *syslogclient) encode(msg SocketMessage) []byte {
+	entry, ok := msg.(*LogMessage)
+	if !ok {
+		entry = &LogMessage{Message: msg.String()}
+	}
+
+	data := "-"
+	params := ""
+	if entry.Caller != "" {
+		params += fmt.Sprintf(` caller="%s"`, escapeParam(entry.Caller))
+	}
+	if entry.Identity != "" {
+		params += fmt.Sprintf(` identity="%s"`, escapeParam(entry.Identity))
+	}
+	for _, key := range entry.Fields.keys() {
+		params += fmt.Sprintf(` %s="%s"`, paramName(key), escapeParam(fmt.Sprint(entry.Fields[key])))
+	}
+	if params != "" {
+		data = "[" + syslogSDID + params + "]"
+	}
+
+	severity, ok := syslogSeverities[entry.LogLevel]
+	if !ok {
+		severity = syslogSeverities[MessageLevelLog]
+	}
+	message := strings.NewReplacer("\r", "#015", "\n", "#012").Replace(strings.TrimSuffix(entry.Message, "\n"))
+	return []byte(fmt.Sprintf("<%d>1 %s %s %s - - %s %s", s.facility*8+severity, messageTime(entry).Format("2006-01-02T15:04:05.000000Z07:00"),
+		headerValue(s.host(entry)), headerValue(s.appName), data, message))
+}
+
+// PARAM-NAME is up to 32 printable ASCII characters other than '=', ' ', ']' and '"'
+func paramName(key string) string {
+	name := fieldName(key, func(r rune) bool {
+		return r > ' ' && r < 127 && r != '=' && r != ']' && r != '"'
+	})
+	if name == "" {
+		return "_"
+	} else if len(name) > 32 {
+		name = name[:32]
+	}
+	return name
+}
+
+// PARAM-VALUE must escape '"', '\' and ']'
+func escapeParam(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`).Replace(value)
+}
+
+// Header fields are printable ASCII without spaces, "-" when empty
+func headerValue(value string) string {
+	value = strings.Map(func(r rune) rune {
+		if r <= ' ' || r > '~' {
+			return -1
+		}
+		return r
+	}, value)
+	if value == "" {
+		return "-"
+	}
+	return value
+}
+
+type UdpSyslogClient struct {
+	udpClient
+	syslogclient
+}
+
+// NewUdpSyslogClient sends messages to a syslog collector, one per datagram
+func NewUdpSyslogClient() SyslogClient {
+	u := &UdpSyslogClient{}
+	u.init(u)
+	u.initSyslogClient()
+	u.framing = framingDatagram
+	return u
+}
+
+type TcpSyslogClient struct {
+	tcpClient
+	syslogclient
+}
+
+// NewTcpSyslogClient sends messages to a syslog collector with octet counting framing (RFC 6587),
+// or over TLS (RFC 5425) once SetTLSConfig is called. Reconnects and buffers like the other TCP clients
+func NewTcpSyslogClient() SyslogClient {
+	t := &TcpSyslogClient{}
+	t.init(t)
+	t.initSyslogClient()
+	t.framing = framingSyslog
+	return t
+}
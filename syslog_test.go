@@ -0,0 +1,161 @@
+package socketlogger
+
+import (
+	"fmt"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseSyslog(t *testing.T) {
+	tests := []struct {
+		frame   string
+		level   messageLevel
+		caller  string
+		message string
+	}{
+		// RFC 5424 examples
+		{`<34>1 2003-10-11T22:14:15.003Z mymachine.example.com su - ID47 - BOM'su root' failed for lonvick on /dev/pts/8`, MessageLevelErr, "mymachine.example.com/su", "BOM'su root' failed for lonvick on /dev/pts/8"},
+		{`<165>1 2003-08-24T05:14:15.000003-07:00 192.0.2.1 myproc 8710 - - %% It's time to make the do-nuts.`, MessageLevelLog, "192.0.2.1/myproc[8710]", "%% It's time to make the do-nuts."},
+		{`<165>1 2003-10-11T22:14:15.003Z mymachine.example.com evntslog - ID47 [exampleSDID@32473 iut="3" eventID="1011" note="a \"quoted\" ]"] An application event`, MessageLevelLog, "mymachine.example.com/evntslog", `[exampleSDID@32473 iut="3" eventID="1011" note="a \"quoted\" ]"] An application event`},
+		{`<15>1 - - - - - -`, MessageLevelDbg, "", ""},
+		{"<12>1 2021-09-14T21:14:51Z rig-7 camera 42 - - \ufeffdropped frame", MessageLevelWrn, "rig-7/camera[42]", "dropped frame"},
+		// RFC 3164
+		{`<34>Oct 11 22:14:15 mymachine su: 'su root' failed for lonvick on /dev/pts/8`, MessageLevelErr, "mymachine/su", "'su root' failed for lonvick on /dev/pts/8"},
+		{`<30>Sep  4 09:01:02 router1 sshd[1234]: Accepted publickey for ops`, MessageLevelLog, "router1/sshd[1234]", "Accepted publickey for ops"},
+		{`<28>kernel: link down on eth0`, MessageLevelWrn, "kernel", "link down on eth0"},
+		{`no priority at all`, MessageLevelLog, "", "no priority at all"},
+	}
+	for _, test := range tests {
+		msg, err := parseSyslog([]byte(test.frame))
+		if err != nil {
+			t.Errorf("%s: %v", test.frame, err)
+			continue
+		}
+		if msg.LogLevel != test.level || msg.Caller != test.caller || msg.Message != test.message {
+			t.Errorf("%s:\nexpected %v %q %q\nactual   %v %q %q", test.frame, test.level, test.caller, test.message, msg.LogLevel, msg.Caller, msg.Message)
+		}
+	}
+
+	if msg, _ := parseSyslog([]byte(tests[1].frame)); msg.Time == nil || !msg.Time.Equal(time.Date(2003, 8, 24, 12, 14, 15, 3000, time.UTC)) {
+		t.Errorf("RFC 5424 timestamp should be kept: %v", msg.Time)
+	}
+	for frame, facility := range map[string]int{tests[1].frame: 20, tests[6].frame: 3, "no priority": 1} {
+		if msg, _ := parseSyslog([]byte(frame)); msg.Fields["facility"] != facility {
+			t.Errorf("%s: facility should be %d, not %v", frame, facility, msg.Fields["facility"])
+		}
+	}
+
+	for _, bad := range []string{"", "<>1 - - - - - -", "<192>x", "<-1>x", "<13>1 - host app", `<13>1 - - - - [unterminated x="1"`} {
+		if _, err := parseSyslog([]byte(bad)); err == nil {
+			t.Errorf("%q should not parse", bad)
+		}
+	}
+}
+
+func TestSyslogServers(t *testing.T) {
+	dir := t.TempDir()
+	udp := NewUdpSyslogServer()
+	udp.SetLogFile(dir, "syslog.log")
+	udpAddr := bindLocal(t, udp)
+	tcp := NewTcpSyslogServer()
+	tcp.SetLogFile(dir, "syslog.log")
+	tcpAddr := bindLocal(t, tcp)
+
+	conn, err := net.Dial("udp", fmt.Sprintf("127.0.0.1:%d", udpAddr.Port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprint(conn, "<11>Oct 11 22:14:15 switch3 lldpd[77]: neighbor lost on port 12")
+	conn.Close()
+
+	conn, err = net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", tcpAddr.Port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	octets := "<14>1 2021-09-14T21:14:51Z db1 postgres 991 - - checkpoint complete\nsecond line"
+	fmt.Fprintf(conn, "%d %s", len(octets), octets)
+	fmt.Fprint(conn, "<15>Sep 14 21:14:52 db1 cron[5]: newline framed\n")
+	conn.Close()
+	udp.Shutdown()
+	tcp.Shutdown()
+
+	assertLogContains(t, filepath.Join(dir, "syslog.log"),
+		"switch3/lldpd[77] -- neighbor lost on port 12",
+		"db1/postgres[991] -- checkpoint complete\nsecond line",
+		"db1/cron[5] -- newline framed",
+	)
+}
+
+func TestSyslogEncode(t *testing.T) {
+	client := NewTcpSyslogClient().(*TcpSyslogClient)
+	client.SetHostname("aggregator 1")
+	client.SetAppName("socketlogger")
+	frame := string(client.encode(&LogMessage{
+		Caller:   `video.go:85`,
+		LogLevel: MessageLevelErr,
+		Message:  "first line\nsecond line\n",
+		envelope: envelope{Identity: `rig-"7"]`},
+	}))
+
+	if !strings.HasPrefix(frame, "<11>1 ") {
+		t.Errorf("Expected user.err priority: %s", frame)
+	}
+	expected := ` aggregator1 socketlogger - - [socketlogger@32473 caller="video.go:85" identity="rig-\"7\"\]"] first line#012second line`
+	if !strings.HasSuffix(frame, expected) {
+		t.Errorf("Expected suffix %s\nactual %s", expected, frame)
+	}
+	if encoded := string(encodeFrame([]byte(frame), framingSyslog)); encoded != fmt.Sprintf("%d %s", len(frame), frame) {
+		t.Errorf("Frame is not octet counted: %s", encoded)
+	}
+	if err := client.SetFacility(24); err == nil {
+		t.Error("Facility 24 was accepted")
+	}
+
+	frame = string(client.encode(&LogMessage{Message: "m", Fields: Fields{"queue": 31, "bad key=": `a"b`}}))
+	if expected := `[socketlogger@32473 bad_key_="a\"b" queue="31"] m`; !strings.HasSuffix(frame, expected) {
+		t.Errorf("Expected fields as structured data %s\nactual %s", expected, frame)
+	}
+}
+
+func TestSyslogForwarding(t *testing.T) {
+	collector, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer collector.Close()
+
+	forwarder := NewUdpSyslogClient()
+	forwarder.SetFacility(16)
+	forwarder.Connect(Connection{Addr: "127.0.0.1"}, connectionOf(collector.LocalAddr()))
+
+	server := NewTcpLoggerServer()
+	server.SetLogFile(t.TempDir(), "forward.log")
+	server.SetSyslogForwarder(forwarder)
+
+	logger := connectLogger(t, bindLocal(t, server))
+	logger.Wrn("forward me")
+	logger.Disconnect()
+	server.Shutdown()
+	forwarder.Disconnect()
+
+	buf := make([]byte, maxDatagramSize)
+	collector.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		n, _, err := collector.ReadFrom(buf)
+		if err != nil {
+			t.Fatal("forwarded message never arrived")
+		}
+		if datagram := string(buf[:n]); strings.Contains(datagram, "forward me") {
+			if !strings.HasPrefix(datagram, "<132>1 ") || !strings.Contains(datagram, `[socketlogger@32473 caller="syslog_test.go:`) {
+				t.Errorf("Unexpected datagram %s", datagram)
+			}
+			if msg, err := parseSyslog(buf[:n]); err != nil || msg.LogLevel != MessageLevelWrn {
+				t.Errorf("Forwarded message did not parse back: %+v %v", msg, err)
+			}
+			return
+		}
+	}
+}
@@ -0,0 +1,325 @@
+package socketlogger
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	tailBufferSize int = 256 // Events queued per viewer before new ones are dropped
+	tailTimeFlags  int = log.Ldate | log.Ltime | log.Lmicroseconds
+)
+
+var ansiColors = regexp.MustCompile("\x1b\\[[0-9;]*m")
+
+// LiveTail streams every message written by the logger servers it is attached to as Server-Sent
+// Events, so the log can be watched from a browser. Attach it with LoggerServer.SetLiveTail
+type LiveTail struct {
+	lock        sync.Mutex
+	viewers     map[*tailViewer]struct{}
+	history     []tailRecord // Ring of the last historySize messages
+	next        int          // Where the next message goes in history once it is full
+	historySize int
+	tlsConfig   *tls.Config
+	srv         *http.Server
+	addr        Connection
+}
+
+// One event sent to viewers, line is the message formatted like a log file line. Servers sharing a
+// tail may use different time flags, so the tail always uses tailTimeFlags
+type tailEvent struct {
+	Time     time.Time `json:"time"`
+	Level    string    `json:"level"`
+	Caller   string    `json:"caller"`
+	Message  string    `json:"message"`
+	Fields   Fields    `json:"fields,omitempty"`
+	Identity string    `json:"identity,omitempty"`
+	Host     string    `json:"host,omitempty"`
+	Line     string    `json:"line"`
+}
+
+// A message with what the filters need, only formatted into an event once a viewer is shown it
+type tailRecord struct {
+	msg    *LogMessage
+	time   time.Time
+	level  string
+	caller string
+	trace  string
+}
+
+type tailViewer struct {
+	levels  map[string]bool // Empty shows every level
+	caller  string          // Only callers containing this are shown
+	trace   string          // Only messages with this trace_id field are shown when set
+	events  chan []byte
+	dropped int // Events skipped because the viewer fell behind, guarded by the LiveTail lock
+}
+
+func NewLiveTail() *LiveTail {
+	return &LiveTail{
+		viewers: make(map[*tailViewer]struct{}),
+	}
+}
+
+// SetHistory keeps the last n messages to replay to new viewers that pass their filters, so ?trace=
+// shows a request that has finished. 0, the default, keeps none
+func (t *LiveTail) SetHistory(n int) error {
+	if n < 0 {
+		return fmt.Errorf("history of %d messages is negative", n)
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.historySize = n
+	t.history, t.next = nil, 0
+	return nil
+}
+
+// SetTLSConfig serves the tail over HTTPS. Must be called before Bind
+func (t *LiveTail) SetTLSConfig(config *tls.Config) error {
+	t.tlsConfig = config
+	return nil
+}
+
+// Bind starts serving the tail. "/" is a page that shows the stream, "/events" is the stream itself.
+// Both take ?level=wrn,err, ?caller=video.go and ?trace=<trace_id> to filter what is shown. Viewers
+// first get the messages kept by SetHistory that pass their filters
+func (t *LiveTail) Bind(c Connection) error {
+	listener, err := net.Listen(tcpProtocol, fmt.Sprintf("%v:%d", c.Addr, c.Port))
+	if err != nil {
+		return err
+	}
+	if t.tlsConfig != nil {
+		listener = tls.NewListener(listener, t.tlsConfig)
+	}
+	t.addr = connectionOf(listener.Addr())
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/events", t.serveEvents)
+	mux.HandleFunc("/", t.servePage)
+	t.srv = &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: httpHeaderTimeout,
+		IdleTimeout:       httpIdleTimeout,
+	}
+	go t.srv.Serve(listener)
+	return nil
+}
+
+// Addr returns where the tail is served, with the port the system picked when Bind was given port 0
+func (t *LiveTail) Addr() Connection {
+	return t.addr
+}
+
+// Shutdown disconnects every viewer and stops serving
+func (t *LiveTail) Shutdown() {
+	t.lock.Lock()
+	for viewer := range t.viewers {
+		close(viewer.events)
+		delete(t.viewers, viewer)
+	}
+	t.lock.Unlock()
+
+	if t.srv != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), httpShutdownTimeout)
+		defer cancel()
+		t.srv.Shutdown(ctx)
+	}
+}
+
+// Called by the logger server writer for every message it writes. Never blocks, a viewer that
+// can't keep up misses messages rather than holding up the log file
+func (t *LiveTail) publish(msg *LogMessage) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.historySize == 0 && len(t.viewers) == 0 {
+		return
+	}
+	record := tailRecord{
+		msg:    msg,
+		time:   time.Now(),
+		level:  levelNames[msg.LogLevel],
+		caller: msg.Caller,
+	}
+	record.trace, _ = msg.Fields[TraceIDField].(string)
+	if len(t.history) < t.historySize {
+		t.history = append(t.history, record)
+	} else if t.historySize > 0 {
+		t.history[t.next] = record
+		t.next = (t.next + 1) % t.historySize
+	}
+
+	var event []byte
+	for viewer := range t.viewers {
+		if !viewer.shows(record) {
+			continue
+		}
+		if event == nil {
+			event = record.event()
+		}
+		select {
+		case viewer.events <- event:
+		default:
+			viewer.dropped++
+		}
+	}
+}
+
+func (r tailRecord) event() []byte {
+	var line bytes.Buffer
+	log.New(&line, "", tailTimeFlags).Print(r.msg)
+	event, _ := json.Marshal(tailEvent{
+		Time:     r.time,
+		Level:    r.level,
+		Caller:   r.caller,
+		Message:  r.msg.Message,
+		Fields:   r.msg.Fields,
+		Identity: r.msg.Identity,
+		Host:     r.msg.Host,
+		Line:     strings.TrimSuffix(ansiColors.ReplaceAllString(line.String(), ""), "\n"),
+	})
+	return event
+}
+
+func (v *tailViewer) shows(record tailRecord) bool {
+	if len(v.levels) > 0 && !v.levels[record.level] {
+		return false
+	} else if v.trace != "" && record.trace != v.trace {
+		return false
+	}
+	return strings.Contains(record.caller, v.caller)
+}
+
+// The kept messages the viewer would have been shown, oldest first. Called with the lock held
+func (t *LiveTail) replay(viewer *tailViewer) []tailRecord {
+	var records []tailRecord
+	for i := range t.history {
+		if record := t.history[(t.next+i)%len(t.history)]; viewer.shows(record) {
+			records = append(records, record)
+		}
+	}
+	return records
+}
+
+func (t *LiveTail) serveEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	viewer := &tailViewer{
+		levels: make(map[string]bool),
+		caller: r.URL.Query().Get("caller"),
+		trace:  r.URL.Query().Get("trace"),
+		events: make(chan []byte, tailBufferSize),
+	}
+	for _, levels := range r.URL.Query()["level"] {
+		for _, level := range strings.Split(levels, ",") {
+			if _, ok := parseLevel(level); !ok {
+				http.Error(w, fmt.Sprintf("unknown level %q", level), http.StatusBadRequest)
+				return
+			}
+			viewer.levels[level] = true
+		}
+	}
+
+	t.lock.Lock()
+	history := t.replay(viewer)
+	t.viewers[viewer] = struct{}{}
+	t.lock.Unlock()
+	defer t.remove(viewer)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	for _, record := range history {
+		fmt.Fprintf(w, "data: %s\n\n", record.event())
+	}
+	flusher.Flush()
+
+	for {
+		select {
+		case event, ok := <-viewer.events:
+			if !ok {
+				return // LiveTail was shut down
+			}
+			t.lock.Lock()
+			dropped := viewer.dropped
+			viewer.dropped = 0
+			t.lock.Unlock()
+			if dropped > 0 {
+				fmt.Fprintf(w, "event: dropped\ndata: %d\n\n", dropped)
+			}
+			fmt.Fprintf(w, "data: %s\n\n", event)
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func (t *LiveTail) remove(viewer *tailViewer) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	delete(t.viewers, viewer)
+}
+
+func (t *LiveTail) servePage(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprint(w, tailPage)
+}
+
+const tailPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>socketlogger</title>
+<style>
+body { background: #111; color: #ddd; font: 13px monospace; margin: 0; padding: 8px; }
+div { white-space: pre-wrap; }
+.wrn { color: #e5c07b; } .success { color: #98c379; } .err { color: #e06c75; } .dbg { color: #56b6c2; }
+.dropped { color: #888; font-style: italic; }
+.trace { cursor: pointer; } .trace:hover { background: #222; }
+</style>
+</head>
+<body>
+<script>
+const events = new EventSource("events" + location.search);
+function show(text, cls) {
+  const follow = window.innerHeight + window.scrollY >= document.body.scrollHeight - 4;
+  const div = document.createElement("div");
+  div.textContent = text;
+  div.className = cls;
+  document.body.appendChild(div);
+  if (follow) window.scrollTo(0, document.body.scrollHeight);
+  return div;
+}
+events.onmessage = e => {
+  const msg = JSON.parse(e.data);
+  const div = show(msg.line, msg.level);
+  const trace = msg.fields && msg.fields.trace_id;
+  if (trace && !new URLSearchParams(location.search).has("trace")) {
+    div.title = "Show trace " + trace;
+    div.className += " trace";
+    div.onclick = () => location.search = "?trace=" + encodeURIComponent(trace);
+  }
+};
+events.addEventListener("dropped", e => show("... " + e.data + " messages dropped ...", "dropped"));
+</script>
+</body>
+</html>
+`
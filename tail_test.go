@@ -0,0 +1,79 @@
+package socketlogger
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLiveTailFilters(t *testing.T) {
+	tail := NewLiveTail()
+	tailAddr := bindLocal(t, tail)
+	defer tail.Shutdown()
+
+	server := NewTcpLoggerServer()
+	server.SetLogFile(t.TempDir(), "tail.log")
+	server.SetLiveTail(tail)
+	remote := bindLocal(t, server)
+	defer server.Shutdown()
+
+	resp, err := http.Get(fmt.Sprintf("http://127.0.0.1:%d/events?level=wrn,err&caller=tail_test.go", tailAddr.Port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Unexpected content type %s", ct)
+	}
+
+	logger := connectLogger(t, remote)
+	logger.Log("not shown, wrong level")
+	logger.Wrn("shown")
+	logger.Err("also shown")
+	logger.Disconnect()
+
+	events := make(chan tailEvent)
+	go func() {
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			if data := strings.TrimPrefix(scanner.Text(), "data: "); data != scanner.Text() {
+				var event tailEvent
+				json.Unmarshal([]byte(data), &event)
+				events <- event
+			}
+		}
+	}()
+
+	for _, expected := range []string{"shown", "also shown"} {
+		select {
+		case event := <-events:
+			if event.Message != expected || !strings.HasPrefix(event.Caller, "tail_test.go") {
+				t.Errorf("Expected %q, actual %+v", expected, event)
+			}
+			if strings.Contains(event.Line, "\x1b") || !strings.Contains(event.Line, expected) {
+				t.Errorf("Line should be the plain formatted message: %q", event.Line)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Timed out waiting for %q", expected)
+		}
+	}
+}
+
+func TestLiveTailUnknownLevel(t *testing.T) {
+	tail := NewLiveTail()
+	tailAddr := bindLocal(t, tail)
+	defer tail.Shutdown()
+
+	resp, err := http.Get(fmt.Sprintf("http://127.0.0.1:%d/events?level=loud", tailAddr.Port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected status %d, actual %d", http.StatusBadRequest, resp.StatusCode)
+	}
+}
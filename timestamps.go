@@ -0,0 +1,84 @@
+package socketlogger
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// Which time the logger server writes in front of every line
+type Timestamps int
+
+const (
+	// When the server wrote the message, the original behaviour
+	TimestampServer Timestamps = 0
+	// When the client created the message. Messages from clients that don't send it get the server time
+	TimestampClient Timestamps = 1
+	// Server time in front, client time after it
+	TimestampBoth Timestamps = 2
+
+	defaultSkewWarning time.Duration = 5 * time.Second
+)
+
+var timestampNames = map[Timestamps]string{
+	TimestampServer: "server",
+	TimestampClient: "client",
+	TimestampBoth:   "both",
+}
+
+func (t Timestamps) String() string {
+	if name, ok := timestampNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("Timestamps(%d)", int(t))
+}
+
+// ParseTimestamps returns the Timestamps for "server", "client" or "both"
+func ParseTimestamps(name string) (Timestamps, error) {
+	for timestamps, n := range timestampNames {
+		if n == name {
+			return timestamps, nil
+		}
+	}
+	return TimestampServer, fmt.Errorf("unknown timestamps %q", name)
+}
+
+// Formats t the way the log package does for flags, so client times line up with server times
+func formatTime(t time.Time, flags int) string {
+	if flags&log.LUTC != 0 {
+		t = t.UTC()
+	}
+	layout := ""
+	if flags&log.Ldate != 0 {
+		layout += "2006/01/02 "
+	}
+	if flags&(log.Ltime|log.Lmicroseconds) != 0 {
+		layout += "15:04:05"
+		if flags&log.Lmicroseconds != 0 {
+			layout += ".000000"
+		}
+		layout += " "
+	}
+	return t.Format(layout)
+}
+
+// Returns a note to add to the line when the client clock is more than threshold away from the server
+// clock. Includes the time the message spent on the way, so it is not used for buffered messages
+func skewNote(clientTime *time.Time, now time.Time, threshold time.Duration) string {
+	if clientTime == nil || threshold <= 0 {
+		return ""
+	}
+	skew := now.Sub(*clientTime)
+	if skew < threshold && skew > -threshold {
+		return ""
+	}
+	return fmt.Sprintf(" [client time off by %v]", skew.Round(time.Millisecond))
+}
+
+// When the client created msg, now if it didn't say
+func messageTime(msg *LogMessage) time.Time {
+	if msg.Time != nil {
+		return *msg.Time
+	}
+	return time.Now()
+}
@@ -0,0 +1,86 @@
+package socketlogger
+
+import (
+	"log"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFormatTime(t *testing.T) {
+	at := time.Date(2021, 9, 14, 21, 14, 50, 998000000, time.Local)
+	for flags, expected := range map[int]string{
+		log.Ldate | log.Ltime:                     "2021/09/14 21:14:50 ",
+		log.Ldate | log.Ltime | log.Lmicroseconds: "2021/09/14 21:14:50.998000 ",
+		log.Lmicroseconds:                         "21:14:50.998000 ",
+		0:                                         "",
+	} {
+		if actual := formatTime(at, flags); actual != expected {
+			t.Errorf("Flags %d: expected %q, actual %q", flags, expected, actual)
+		}
+	}
+}
+
+func TestSkewNote(t *testing.T) {
+	now := time.Now()
+	ahead, behind := now.Add(-time.Second), now.Add(-time.Minute)
+	if note := skewNote(&ahead, now, defaultSkewWarning); note != "" {
+		t.Errorf("A second is within the threshold: %q", note)
+	}
+	if note := skewNote(&behind, now, defaultSkewWarning); note != " [client time off by 1m0s]" {
+		t.Errorf("Unexpected note %q", note)
+	}
+	if note := skewNote(&behind, now, -1); note != "" {
+		t.Errorf("Warning is turned off: %q", note)
+	}
+	if note := skewNote(nil, now, defaultSkewWarning); note != "" {
+		t.Errorf("Messages without a client time can't be flagged: %q", note)
+	}
+}
+
+func TestNoSkewForBuffered(t *testing.T) {
+	var out strings.Builder
+	sink := NewTextSink(&out)
+	created := time.Now().Add(-time.Hour)
+	sink.Write(&LogMessage{Message: "held while the server was down", envelope: envelope{Time: &created, Buffered: true}})
+	if strings.Contains(out.String(), "off by") {
+		t.Errorf("Buffered messages should not be flagged: %s", out.String())
+	}
+}
+
+func TestClientTimestamps(t *testing.T) {
+	server, path, remote := startLoggerServer(t)
+	server.SetTimestamps(TimestampBoth)
+	if err := server.SetTimestamps(Timestamps(7)); err == nil {
+		t.Error("Unknown timestamps should be rejected")
+	}
+
+	logger := connectLogger(t, remote)
+	sent := time.Now().Add(-time.Hour)
+	logger.Send(&LogMessage{
+		Message:  "from a slow clock",
+		Caller:   "rig.go:1",
+		LogLevel: MessageLevelLog,
+		envelope: envelope{Time: &sent},
+	})
+	logger.Log("on time")
+	logger.Disconnect()
+	server.Shutdown()
+
+	dat := assertLogContains(t, path)
+	var slow, onTime string
+	for _, line := range strings.Split(dat, "\n") {
+		if strings.Contains(line, "from a slow clock") {
+			slow = line
+		} else if strings.Contains(line, "on time") {
+			onTime = line
+		}
+	}
+	if expected := "| client " + strings.TrimSpace(formatTime(sent, log.Flags())); !strings.Contains(slow, expected) ||
+		!strings.Contains(slow, "[client time off by 1h0m0") {
+		t.Errorf("Expected client time %q and a skew warning:\n%s", expected, dat)
+	}
+	if !strings.Contains(onTime, "| client ") || strings.Contains(onTime, "off by") {
+		t.Errorf("Expected client time without a skew warning:\n%s", dat)
+	}
+}
@@ -0,0 +1,101 @@
+package socketlogger
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"os"
+	"time"
+)
+
+const tlsHandshakeTimeout time.Duration = 10 * time.Second
+
+// NewServerTLSConfig loads the certificate/key pair the server presents. If caFile
+// is not empty, clients must present a certificate signed by one of the CAs in it
+func NewServerTLSConfig(certFile, keyFile, caFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if caFile != "" {
+		if config.ClientCAs, err = loadCertPool(caFile); err != nil {
+			return nil, err
+		}
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
+
+// NewClientTLSConfig builds the config for a client. caFile is the bundle used to verify
+// the server (system roots if empty), certFile/keyFile are only needed for mutual TLS
+func NewClientTLSConfig(certFile, keyFile, caFile string) (*tls.Config, error) {
+	var err error
+	config := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	if caFile != "" {
+		if config.RootCAs, err = loadCertPool(caFile); err != nil {
+			return nil, err
+		}
+	}
+	return config, nil
+}
+
+func loadCertPool(caFile string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %s", caFile)
+	}
+	return pool, nil
+}
+
+// Returns the identity of a verified client certificate, empty if the connection is
+// not TLS or the client did not present a certificate
+func peerIdentity(sock net.Conn) (string, error) {
+	conn, ok := sock.(*tls.Conn)
+	if !ok {
+		return "", nil
+	}
+
+	conn.SetDeadline(time.Now().Add(tlsHandshakeTimeout))
+	defer conn.SetDeadline(time.Time{})
+	if err := conn.Handshake(); err != nil {
+		return "", err
+	}
+
+	return stateIdentity(conn.ConnectionState()), nil
+}
+
+// Picks the most readable name out of the verified client certificate
+func stateIdentity(state tls.ConnectionState) string {
+	if len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return ""
+	}
+	cert := state.VerifiedChains[0][0]
+	switch {
+	case cert.Subject.CommonName != "":
+		return cert.Subject.CommonName
+	case len(cert.DNSNames) > 0:
+		return cert.DNSNames[0]
+	case len(cert.EmailAddresses) > 0:
+		return cert.EmailAddresses[0]
+	}
+	return cert.Subject.String()
+}
@@ -0,0 +1,145 @@
+package socketlogger
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestTLSMutualAuth(t *testing.T) {
+	dir := t.TempDir()
+	ca, caKey := writeCert(t, dir, "ca", nil, nil, pkix.Name{CommonName: "socketlogger test ca"})
+	writeCert(t, dir, "server", ca, caKey, pkix.Name{CommonName: "127.0.0.1"})
+	writeCert(t, dir, "client", ca, caKey, pkix.Name{CommonName: "rig-7"})
+
+	serverConfig, err := NewServerTLSConfig(filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key"), filepath.Join(dir, "ca.crt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientConfig, err := NewClientTLSConfig(filepath.Join(dir, "client.crt"), filepath.Join(dir, "client.key"), filepath.Join(dir, "ca.crt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := NewTcpLoggerServer()
+	server.SetLogFile(dir, "tls.log")
+	server.SetTLSConfig(serverConfig)
+	remote := bindLocal(t, server)
+
+	logger := NewTcpLoggerClient()
+	logger.SetTLSConfig(clientConfig)
+	logger.Connect(Connection{
+		Addr: "127.0.0.1",
+		Port: 0,
+	}, remote)
+	logger.Log("hello over TLS")
+
+	logger.Disconnect()
+	server.Shutdown()
+
+	assertLogContains(t, filepath.Join(dir, "tls.log"), "[rig-7]", "hello over TLS")
+}
+
+// A client that finishes its handshake after Shutdown started and then goes quiet is still cut off
+func TestShutdownDuringHandshake(t *testing.T) {
+	dir := t.TempDir()
+	ca, caKey := writeCert(t, dir, "ca", nil, nil, pkix.Name{CommonName: "socketlogger test ca"})
+	writeCert(t, dir, "server", ca, caKey, pkix.Name{CommonName: "127.0.0.1"})
+	writeCert(t, dir, "client", ca, caKey, pkix.Name{CommonName: "rig-7"})
+	serverConfig, err := NewServerTLSConfig(filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key"), filepath.Join(dir, "ca.crt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientConfig, err := NewClientTLSConfig(filepath.Join(dir, "client.crt"), filepath.Join(dir, "client.key"), filepath.Join(dir, "ca.crt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := NewTcpLoggerServer()
+	server.SetOutput(io.Discard)
+	server.SetTLSConfig(serverConfig)
+	remote := bindLocal(t, server)
+	raw, err := net.Dial(tcpProtocol, net.JoinHostPort(remote.Addr, strconv.Itoa(remote.Port)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer raw.Close()
+	time.Sleep(50 * time.Millisecond) // Accepted, the server waits for the handshake
+
+	stopped := make(chan bool)
+	go func() {
+		server.Shutdown()
+		close(stopped)
+	}()
+	clientConfig.ServerName = remote.Addr
+	if err := tls.Client(raw, clientConfig).Handshake(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-stopped:
+	case <-time.After(3 * time.Second):
+		t.Fatal("Shutdown waited for an idle client")
+	}
+}
+
+func TestUDPTLSUnsupported(t *testing.T) {
+	if err := NewUdpLoggerServer().SetTLSConfig(nil); err == nil {
+		t.Error("UDP server accepted a TLS config")
+	}
+	if err := NewUdpCsvClient().SetTLSConfig(nil); err == nil {
+		t.Error("UDP client accepted a TLS config")
+	}
+}
+
+// Writes <name>.crt and <name>.key to dir. The certificate is self signed when parent is nil
+func writeCert(t *testing.T, dir, name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey, subject pkix.Name) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      subject,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+		parent, parentKey = template, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(filepath.Join(dir, name+".crt"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600)
+	os.WriteFile(filepath.Join(dir, name+".key"), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0o600)
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
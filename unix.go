@@ -0,0 +1,142 @@
+package socketlogger
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+	"time"
+)
+
+// Stream socket on a filesystem path, accepts connections the same way as the TCP server
+type unixserver struct {
+	tcpserver
+}
+
+func (u *unixserver) buildSocket(c Connection) (net.Conn, error) {
+	listener, err := net.Listen(unixProtocol, c.Path)
+	if inUse(err) {
+		if err = removeStaleSocket(c.Path); err == nil {
+			listener, err = net.Listen(unixProtocol, c.Path)
+		}
+	}
+	if err == nil {
+		u.serve(listener, "Unix Server", c.Path) // Closing the listener removes the socket file
+	}
+	return nil, err
+}
+
+// Datagram socket on a filesystem path, every datagram is read on its own like the UDP server
+type unixgramserver struct {
+	udpserver
+}
+
+func (u *unixgramserver) buildSocket(c Connection) (net.Conn, error) {
+	sock, err := listenUnixgram(c.Path)
+	if err == nil {
+		u.addr = connectionOf(sock.LocalAddr())
+		u.submit(newLogMessage(MessageLevelSuccess, "%s listening at %s", "Unixgram Server", c.Path))
+		u.onShutdown(func() {
+			os.Remove(c.Path)
+		})
+	}
+	return sock, err
+}
+
+type unixClient struct {
+	tcpClient
+}
+
+func (u *unixClient) buildSocket(local Connection, remote Connection) (string, net.Conn, error) {
+	u.network = unixProtocol
+	u.address = remote.Path
+	u.serverName = "localhost"
+
+	protocol := "Unix Client"
+	if u.tlsConfig != nil {
+		protocol = "TLS Unix Client"
+	}
+	return u.connect(protocol)
+}
+
+type unixgramClient struct {
+	udpClient
+	localPath string
+}
+
+func (u *unixgramClient) Disconnect() {
+	u.udpClient.Disconnect()
+	if u.localPath != "" {
+		os.Remove(u.localPath)
+	}
+}
+
+// Binding to local.Path is optional, but it is the only way to receive acks in reliable mode
+func (u *unixgramClient) buildSocket(local Connection, remote Connection) (string, net.Conn, error) {
+	var sock *net.UnixConn
+	var err error
+	raddr := &net.UnixAddr{Name: remote.Path, Net: unixgramProtocol}
+	if local.Path != "" {
+		if sock, err = listenUnixgram(local.Path); err == nil {
+			u.remoteAddr = raddr
+			u.localPath = local.Path
+		}
+	} else if u.reliable {
+		err = fmt.Errorf("reliable mode needs a local socket path to receive acks on")
+	} else {
+		sock, err = net.DialUnix(unixgramProtocol, nil, raddr)
+	}
+
+	if err != nil {
+		return "Unixgram Client", nil, err
+	}
+	u.session = newSessionID()
+	u.setStatus(StatusConnected, nil)
+	return "Unixgram Client", sock, nil
+}
+
+// Binds a datagram socket to path, taking over a socket file left behind by a crash
+func listenUnixgram(path string) (*net.UnixConn, error) {
+	addr := &net.UnixAddr{Name: path, Net: unixgramProtocol}
+	sock, err := net.ListenUnixgram(unixgramProtocol, addr)
+	if inUse(err) {
+		if err = removeStaleSocket(path); err == nil {
+			sock, err = net.ListenUnixgram(unixgramProtocol, addr)
+		}
+	}
+	return sock, err
+}
+
+// Binding a path fails this way whether the socket file is stale or something is listening on it
+func inUse(err error) bool {
+	return errors.Is(err, syscall.EADDRINUSE)
+}
+
+// Removes a socket file left behind by a server or client that did not shut down cleanly. Fails if
+// something is still listening on it, or the path is not a socket. Only called once binding the path
+// has failed, and probes it with a datagram socket so a live stream server never sees a connection
+func removeStaleSocket(path string) error {
+	if path == "" {
+		return fmt.Errorf("no socket path given")
+	}
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s exists and is not a socket", path)
+	}
+
+	// Refused means nothing is bound to the file. A stream server rejects the probe as the wrong type
+	conn, err := net.DialTimeout(unixgramProtocol, path, 100*time.Millisecond)
+	if err == nil {
+		conn.Close()
+	}
+	if !errors.Is(err, syscall.ECONNREFUSED) {
+		return fmt.Errorf("%s is in use", path)
+	}
+	return os.Remove(path)
+}
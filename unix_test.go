@@ -0,0 +1,100 @@
+package socketlogger
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestUnixStream(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "logger.sock")
+
+	// Left behind by a server that crashed
+	stale, err := net.ListenUnix(unixProtocol, &net.UnixAddr{Name: path, Net: unixProtocol})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.SetUnlinkOnClose(false)
+	stale.Close()
+
+	server := NewUnixLoggerServer()
+	server.SetLogFile(dir, "unix.log")
+	if err := server.Bind(Connection{Path: path}); err != nil {
+		t.Fatalf("Stale socket was not cleaned up: %v", err)
+	}
+	if err := NewUnixLoggerServer().Bind(Connection{Path: path}); err == nil {
+		t.Error("Second server took over a socket that is in use")
+	}
+
+	logger := NewUnixLoggerClient()
+	logger.Connect(Connection{}, Connection{Path: path})
+	logger.Log("hello over a unix socket")
+	logger.Disconnect()
+	server.Shutdown()
+
+	dat := assertLogContains(t, filepath.Join(dir, "unix.log"), "hello over a unix socket")
+	if n := strings.Count(dat, "Socket disconnected"); n != 1 {
+		t.Errorf("Only the logger should have connected, the second server did %d times:\n%s", n-1, dat)
+	}
+	if fileExists(path) {
+		t.Errorf("%s was not removed on shutdown", path)
+	}
+}
+
+func TestUnixgramCsv(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "csv.sock")
+
+	server := NewUnixgramCsvServer()
+	server.SetOutputCsvDirectory(dir)
+	if err := server.Bind(Connection{Path: path}); err != nil {
+		t.Fatal(err)
+	}
+
+	client := NewUnixgramCsvClient()
+	if err := client.Connect(Connection{}, Connection{Path: path}); err != nil {
+		t.Fatal(err)
+	}
+	client.NewCsvFile("unixgram.csv", []interface{}{"a", "b"})
+	client.AppendRow("unixgram.csv", []interface{}{1, 2})
+	client.Disconnect()
+	server.Shutdown()
+
+	dat, _ := os.ReadFile(filepath.Join(dir, "unixgram.csv"))
+	if expected := "a,b\n1,2\n"; string(dat) != expected {
+		t.Errorf("Expected %q, actual %q", expected, dat)
+	}
+	if fileExists(path) {
+		t.Errorf("%s was not removed on shutdown", path)
+	}
+}
+
+// A client that could not connect returns the error, and logging and Disconnect still don't block
+func TestUnixgramConnectFails(t *testing.T) {
+	missing := Connection{Path: filepath.Join(t.TempDir(), "missing.sock")}
+	reliable := NewUnixgramLoggerClient()
+	reliable.SetReliable(true)
+	for _, logger := range []LoggerClient{NewUnixgramLoggerClient(), reliable} {
+		if err := logger.Connect(Connection{}, missing); err == nil {
+			t.Errorf("Expected an error connecting to %s", missing.Path)
+		}
+		for i := 0; i < 200; i++ {
+			logger.Log("nobody is listening")
+		}
+		logger.Disconnect()
+		if status := logger.Status(); status != StatusDisconnected {
+			t.Errorf("Expected %v, actual %v", StatusDisconnected, status)
+		}
+	}
+}
+
+func TestRemoveStaleSocketKeepsFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "not_a_socket")
+	createFile(path)
+	if err := removeStaleSocket(path); err == nil || !fileExists(path) {
+		t.Error("Regular file was removed")
+	}
+}